@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// installationTokenMargin refreshes installation tokens before they expire, so
+// tokens handed to git don't expire while a rebase is in flight
+const installationTokenMargin = 10 * time.Minute
+
+// machineManPreview is required by the installation APIs
+const machineManPreview = "application/vnd.github.machine-man-preview+json"
+
+// credentials authenticate the bot with github, either with a personal access
+// token or as a github app
+type credentials interface {
+	// Client returns a client for the api of a repository
+	Client(owner, name string) (*github.Client, error)
+	// Token returns a token for git to fetch and push a repository
+	Token(owner, name string) (string, error)
+}
+
+// tokenCredentials use a single personal access token for all repositories
+type tokenCredentials struct {
+	client *github.Client
+	token  string
+}
+
+func (c tokenCredentials) Client(owner, name string) (*github.Client, error) {
+	return c.client, nil
+}
+
+func (c tokenCredentials) Token(owner, name string) (string, error) {
+	return c.token, nil
+}
+
+// githubApp authenticates as a github app. It signs short lived JWTs with the
+// private key of the app and exchanges them for installation tokens, which are
+// refreshed before they expire
+type githubApp struct {
+	id  int
+	key *rsa.PrivateKey
+	// client is authenticated as the app itself
+	client *github.Client
+	now    func() time.Time
+
+	mu sync.Mutex
+	// installations contains the installation of every repository looked up so far
+	installations map[string]int
+	tokens        map[int]oauth2.TokenSource
+	clients       map[int]*github.Client
+}
+
+func newGitHubApp(id int, key *rsa.PrivateKey, host githubHost) *githubApp {
+	a := &githubApp{
+		id:            id,
+		key:           key,
+		now:           time.Now,
+		installations: map[string]int{},
+		tokens:        map[int]oauth2.TokenSource{},
+		clients:       map[int]*github.Client{},
+	}
+	a.client = host.Client(&http.Client{Transport: appTransport{app: a, base: http.DefaultTransport}})
+	return a
+}
+
+// loadGitHubApp reads the PEM encoded private key of an app
+func loadGitHubApp(id int, keyPath string, host githubHost) (*githubApp, error) {
+	b, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return newGitHubApp(id, key, host), nil
+}
+
+// parsePrivateKey parses PKCS#1 keys as downloaded from github, as well as PKCS#8 keys
+func parsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not a RSA key")
+	}
+	return rsaKey, nil
+}
+
+// jwt returns a token authenticating as the app. Tokens are valid for 10 minutes
+// at most, so they are backdated a minute to allow for clock drift
+func (a *githubApp) jwt() (string, error) {
+	now := a.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": int64(a.id),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := fmt.Sprintf("%s.%s", base64.RawURLEncoding.EncodeToString(header), base64.RawURLEncoding.EncodeToString(claims))
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s", unsigned, base64.RawURLEncoding.EncodeToString(sig)), nil
+}
+
+// appTransport authenticates requests as the app
+type appTransport struct {
+	app  *githubApp
+	base http.RoundTripper
+}
+
+func (t appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.app.jwt()
+	if err != nil {
+		return nil, err
+	}
+	// round trippers must not modify the original request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return t.base.RoundTrip(r)
+}
+
+// installationTokenSource mints tokens of a single installation
+type installationTokenSource struct {
+	app *githubApp
+	id  int
+}
+
+func (s installationTokenSource) Token() (*oauth2.Token, error) {
+	req, err := s.app.client.NewRequest("POST", fmt.Sprintf("app/installations/%d/access_tokens", s.id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", machineManPreview)
+
+	var token struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if _, err := s.app.client.Do(context.Background(), req, &token); err != nil {
+		return nil, fmt.Errorf("creating token of installation %d failed: %v", s.id, err)
+	}
+	return &oauth2.Token{AccessToken: token.Token, Expiry: token.ExpiresAt.Add(-installationTokenMargin)}, nil
+}
+
+// installation returns the id of the installation of the app on a repository
+func (a *githubApp) installation(owner, name string) (int, error) {
+	key := strings.ToLower(fmt.Sprintf("%s/%s", owner, name))
+	a.mu.Lock()
+	id, ok := a.installations[key]
+	a.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	req, err := a.client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/installation", owner, name), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", machineManPreview)
+	var installation github.Installation
+	if _, err := a.client.Do(context.Background(), req, &installation); err != nil {
+		return 0, fmt.Errorf("%s/%s: looking up installation failed: %v", owner, name, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.installations[key] = installation.GetID()
+	return installation.GetID(), nil
+}
+
+// installationClient returns a client authenticated as an installation. Clients
+// share a token source per installation, which refreshes tokens as necessary
+func (a *githubApp) installationClient(id int) *github.Client {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.clients[id]; ok {
+		return c
+	}
+	ts := oauth2.ReuseTokenSource(nil, installationTokenSource{app: a, id: id})
+	a.tokens[id] = ts
+	c := github.NewClient(oauth2.NewClient(oauth2.NoContext, ts))
+	c.BaseURL, c.UploadURL = a.client.BaseURL, a.client.UploadURL
+	a.clients[id] = c
+	return c
+}
+
+// Client returns a client authenticated as the installation of a repository
+func (a *githubApp) Client(owner, name string) (*github.Client, error) {
+	id, err := a.installation(owner, name)
+	if err != nil {
+		return nil, err
+	}
+	return a.installationClient(id), nil
+}
+
+// Token returns the current token of the installation of a repository
+func (a *githubApp) Token(owner, name string) (string, error) {
+	id, err := a.installation(owner, name)
+	if err != nil {
+		return "", err
+	}
+	a.installationClient(id)
+
+	a.mu.Lock()
+	ts := a.tokens[id]
+	a.mu.Unlock()
+	token, err := ts.Token()
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// Repositories lists all repositories the app is installed on
+func (a *githubApp) Repositories() ([]*github.Repository, error) {
+	var installations []*github.Installation
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := a.client.Apps.ListInstallations(context.Background(), opt)
+		if err != nil {
+			return nil, fmt.Errorf("listing installations failed: %v", err)
+		}
+		installations = append(installations, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	var rs []*github.Repository
+	for _, installation := range installations {
+		client := a.installationClient(installation.GetID())
+		opt := &github.ListOptions{PerPage: 100}
+		for {
+			page, resp, err := client.Apps.ListRepos(context.Background(), opt)
+			if err != nil {
+				return nil, fmt.Errorf("listing repositories of installation %d failed: %v", installation.GetID(), err)
+			}
+			for _, r := range page {
+				a.mu.Lock()
+				a.installations[strings.ToLower(r.GetFullName())] = installation.GetID()
+				a.mu.Unlock()
+			}
+			rs = append(rs, page...)
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+	return rs, nil
+}
+
+// discoverConfig configures all repositories the app is installed on. Their
+// default branch is used as mainline and events are delivered by the app
+func discoverConfig(app *githubApp, mergeLabel string) (*config, error) {
+	rs, err := app.Repositories()
+	if err != nil {
+		return nil, err
+	}
+	register := false
+	c := config{}
+	for _, r := range rs {
+		c.Repositories = append(c.Repositories, repositoryConfig{
+			Repository: r.GetFullName(),
+			Mainline:   r.GetDefaultBranch(),
+			MergeLabel: mergeLabel,
+			Hook:       hookSettings{Register: &register},
+		})
+	}
+	return &c, nil
+}
+
+// watchInstallations discovers repositories periodically, or when the app is
+// installed on new repositories, and applies them
+func watchInstallations(interval time.Duration, installed <-chan struct{}, discover func() (*config, error), apply func(repositories) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-installed:
+			if !ok {
+				return
+			}
+			log.Printf("Installations changed, discovering repositories.\n")
+		case <-ticker.C:
+		}
+
+		cfg, err := discover()
+		if err != nil {
+			log.Printf("discovering repositories failed: %v\n", err)
+			continue
+		}
+		// uninstalling the app from all repositories removes all of them
+		if len(cfg.Repositories) == 0 {
+			err = nil
+		} else {
+			err = cfg.validate()
+		}
+		if err != nil {
+			log.Printf("invalid configuration: %v\n", err)
+			continue
+		}
+		if err := apply(cfg.repositories()); err != nil {
+			log.Printf("applying repositories failed: %v\n", err)
+		}
+	}
+}
+
+// appWebhook receives the webhooks of a github app for all its repositories and
+// dispatches them to the pipeline of their repository
+type appWebhook struct {
+	secret string
+	router *router
+	// installed is notified when the app is installed on other repositories
+	installed chan<- struct{}
+}
+
+func (h appWebhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// the pipeline verifies the signature of the original body once more
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	payload, err := validatePayload(req, h.secret)
+	if err != nil {
+		log.Printf("rejecting app event from %s: %v\n", req.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Header.Get("X-GitHub-Event") {
+	case "installation", "installation_repositories":
+		select {
+		case h.installed <- struct{}{}:
+		default:
+			// a discovery is pending already
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	var evt struct {
+		Repository *github.Repository `json:"repository"`
+	}
+	json.Unmarshal(payload, &evt)
+	parts := strings.Split(evt.Repository.GetFullName(), "/")
+	if len(parts) != 2 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	handler, ok := h.router.Lookup(eventPath(parts[0], parts[1]))
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	handler.ServeHTTP(w, req)
+}
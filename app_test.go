@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testGitHubApp(t *testing.T, mux *http.ServeMux) (*githubApp, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	srv := httptest.NewServer(mux)
+	app := newGitHubApp(42, key, defaultGitHubHost)
+	app.client.BaseURL, _ = url.Parse(srv.URL + "/")
+	return app, srv.Close
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for name, block := range map[string]*pem.Block{
+		"pkcs1": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		"pkcs8": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		parsed, err := parsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if parsed.N.Cmp(key.N) != 0 {
+			t.Fatalf("%s: Expected the generated key, but got another one", name)
+		}
+	}
+
+	if _, err := parsePrivateKey([]byte("not a key")); err == nil {
+		t.Fatal("Expected an error for invalid keys")
+	}
+}
+
+func TestGitHubApp_jwt(t *testing.T) {
+	app, done := testGitHubApp(t, http.NewServeMux())
+	defer done()
+	now := time.Unix(1500000000, 0)
+	app.now = func() time.Time { return now }
+
+	token, err := app.jwt()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a signed JWT, but got %q", token)
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&app.key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Fatalf("Expected a valid signature, but got %v", err)
+	}
+
+	var claims map[string]int64
+	b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(b, &claims)
+	if claims["iss"] != 42 || claims["iat"] != now.Unix()-60 || claims["exp"] != now.Unix()+540 {
+		t.Fatalf("Unexpected claims %v", claims)
+	}
+}
+
+func TestGitHubApp_Token(t *testing.T) {
+	minted := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test/test/installation", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			t.Errorf("Expected the app to authenticate with a JWT, but got %q", r.Header.Get("Authorization"))
+		}
+		fmt.Fprint(w, `{"id": 7}`)
+	})
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Unexpected method %s", r.Method)
+		}
+		minted++
+		w.WriteHeader(http.StatusCreated)
+		// the first token is about to expire, so the second one is minted right away
+		expiry := time.Now().Add(time.Hour)
+		if minted == 1 {
+			expiry = time.Now().Add(installationTokenMargin + time.Second)
+		}
+		fmt.Fprintf(w, `{"token": "token-%d", "expires_at": %q}`, minted, expiry.Format(time.RFC3339))
+	})
+	app, done := testGitHubApp(t, mux)
+	defer done()
+
+	token, err := app.Token("test", "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if token != "token-1" {
+		t.Fatalf("Expected token-1, but got %q", token)
+	}
+
+	if token, err := app.Token("Test", "test"); err != nil || token != "token-2" {
+		t.Fatalf("Expected the token to be refreshed, but got %q, %v", token, err)
+	}
+	if token, err := app.Token("test", "test"); err != nil || token != "token-2" || minted != 2 {
+		t.Fatalf("Expected the token to be reused, but got %q, %v after %d tokens", token, err, minted)
+	}
+}
+
+func TestDiscoverConfig(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/installations", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 7}]`)
+	})
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "token", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	mux.HandleFunc("/installation/repositories", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Expected the installation token, but got %q", r.Header.Get("Authorization"))
+		}
+		fmt.Fprint(w, `{"repositories": [{"full_name": "test/test", "default_branch": "main"}]}`)
+	})
+	app, done := testGitHubApp(t, mux)
+	defer done()
+
+	cfg, err := discoverConfig(app, "LGTM")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err.Error())
+	}
+	rs := cfg.repositories()
+	if len(rs) != 1 || rs[0].Owner != "test" || rs[0].Mainline != "main" || rs[0].registerHook {
+		t.Fatalf("Expected test/test to be discovered, but got %v", rs)
+	}
+	if id, err := app.installation("test", "test"); err != nil || id != 7 {
+		t.Fatalf("Expected installation 7 to be remembered, but got %d, %v", id, err)
+	}
+}
+
+func TestAppWebhook(t *testing.T) {
+	rt := newRouter()
+	dispatched := ""
+	rt.Handle(eventPath("Test", "test"), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := validatePayload(req, "secret"); err != nil {
+			t.Errorf("Expected the pipeline to receive a valid payload, but got %v", err)
+		}
+		dispatched = req.Header.Get("X-GitHub-Event")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	installed := make(chan struct{}, 1)
+	h := appWebhook{secret: "secret", router: rt, installed: installed}
+
+	send := func(event, payload string) int {
+		req := signedRequest("X-Hub-Signature-256", "sha256="+sign(sha256.New, "secret", payload), payload)
+		if strings.HasPrefix(payload, "payload=") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.Header.Set("X-GitHub-Event", event)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("pull_request", `{"repository": {"full_name": "test/test"}}`); code != http.StatusAccepted || dispatched != "pull_request" {
+		t.Fatalf("Expected the event to be dispatched, but got %d", code)
+	}
+	dispatched = ""
+	if code := send("issue_comment", "payload="+url.QueryEscape(`{"repository": {"full_name": "test/test"}}`)); code != http.StatusAccepted || dispatched != "issue_comment" {
+		t.Fatalf("Expected form encoded events to be dispatched, but got %d", code)
+	}
+	if code := send("pull_request", `{"repository": {"full_name": "test/other"}}`); code != http.StatusNotFound {
+		t.Fatalf("Expected unknown repositories to be rejected, but got %d", code)
+	}
+	send("installation_repositories", `{"action": "added"}`)
+	send("installation_repositories", `{"action": "added"}`)
+	if len(installed) != 1 {
+		t.Fatal("Expected a single discovery to be requested")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// autorebase keeps pull requests labeled with one of labels up to date with
+// mainline without merging them. Pull requests which are also labeled with
+// mergeLabel are merged as usual and passed on, just like all other pull requests
+func autorebase(issueClient IssueGetter, labels []string, mergeLabel string, rebase func(*github.PullRequest) bool, input <-chan *github.PullRequest) <-chan *github.PullRequest {
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for pr := range input {
+			if pr.GetState() != "open" {
+				ret <- pr
+				continue
+			}
+
+			issue, _, err := issueClient.Get(
+				context.Background(),
+				pr.Base.Repo.Owner.GetLogin(),
+				pr.Base.Repo.GetName(),
+				pr.GetNumber(),
+			)
+			if err != nil {
+				log.Printf("%s/%s: pr %d failed to lookup issue %s.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber(), err.Error())
+				ret <- pr
+				continue
+			}
+
+			merge, keepRebased := false, false
+			for _, label := range issue.Labels {
+				merge = merge || (mergeLabel != "" && strings.EqualFold(label.GetName(), mergeLabel))
+				for _, l := range labels {
+					keepRebased = keepRebased || strings.EqualFold(label.GetName(), l)
+				}
+			}
+
+			if keepRebased && !merge {
+				log.Printf("%s/%s: pr %d is rebased without merging.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber())
+				rebase(pr)
+				continue
+			}
+			ret <- pr
+		}
+		close(ret)
+	}()
+	return ret
+}
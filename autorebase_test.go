@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestAutorebase(t *testing.T) {
+	pr := func(number int) *github.PullRequest {
+		return &github.PullRequest{
+			State:  stringVal("open"),
+			Number: intVal(number),
+			Base: &github.PullRequestBranch{
+				Repo: &github.Repository{
+					Owner: &github.User{Login: stringVal("test")},
+					Name:  stringVal("test"),
+				},
+			},
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		labels       []string
+		rebaseLabels []string
+		mergeLabel   string
+		rebased      bool
+	}{
+		"rebase label": {
+			labels:       []string{"autorebase"},
+			rebaseLabels: []string{"autorebase"},
+			mergeLabel:   "LGTM",
+			rebased:      true,
+		},
+		"rebase and merge label": {
+			labels:       []string{"autorebase", "LGTM"},
+			rebaseLabels: []string{"autorebase"},
+			mergeLabel:   "LGTM",
+		},
+		"rebase mode": {
+			labels:       []string{"LGTM"},
+			rebaseLabels: []string{"lgtm"},
+			rebased:      true,
+		},
+		"unlabeled": {
+			rebaseLabels: []string{"autorebase"},
+			mergeLabel:   "LGTM",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			issueClient := fakeIssueGetter(func() (*github.Issue, *github.Response, error) {
+				issue := &github.Issue{}
+				for _, label := range tc.labels {
+					issue.Labels = append(issue.Labels, github.Label{Name: stringVal(label)})
+				}
+				return issue, nil, nil
+			})
+
+			var rebased []int
+			ch := make(chan *github.PullRequest, 1)
+			out := autorebase(issueClient, tc.rebaseLabels, tc.mergeLabel, func(pr *github.PullRequest) bool {
+				rebased = append(rebased, pr.GetNumber())
+				return true
+			}, ch)
+			ch <- pr(1)
+			close(ch)
+
+			passed := 0
+			for range out {
+				passed++
+			}
+			if tc.rebased && (len(rebased) != 1 || passed != 0) {
+				t.Fatalf("Expected the pull request to be rebased only, but got %v and %d passed", rebased, passed)
+			}
+			if !tc.rebased && (len(rebased) != 0 || passed != 1) {
+				t.Fatalf("Expected the pull request to be passed on, but got %v and %d passed", rebased, passed)
+			}
+		})
+	}
+}
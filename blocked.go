@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+const defaultBlockedLabel = "rebase-bot:blocked"
+
+// LabelClient edits the labels of issues and notifies their authors
+type LabelClient interface {
+	RemoveLabelForIssue(context.Context, string, string, int, string) (*github.Response, error)
+	AddLabelsToIssue(context.Context, string, string, int, []string) ([]*github.Label, *github.Response, error)
+	CreateComment(context.Context, string, string, int, *github.IssueComment) (*github.IssueComment, *github.Response, error)
+}
+
+// unlabeler takes pull requests which failed definitely out of the queue by
+// removing the merge label, so re-labeling them is the explicit way to try again.
+// A nil unlabeler keeps pull requests labeled
+type unlabeler struct {
+	owner      string
+	name       string
+	mergeLabel string
+	// blockedLabel replaces the merge label if it's not empty
+	blockedLabel string
+	client       LabelClient
+
+	mu sync.Mutex
+	// blocked contains the pull requests labeled with blockedLabel
+	blocked map[int]bool
+}
+
+func newUnlabeler(owner, name, mergeLabel, blockedLabel string, client LabelClient) *unlabeler {
+	return &unlabeler{
+		owner:        owner,
+		name:         name,
+		mergeLabel:   mergeLabel,
+		blockedLabel: blockedLabel,
+		client:       client,
+		blocked:      map[int]bool{},
+	}
+}
+
+// Unlabel removes the merge label of a pull request and notifies its author why
+func (u *unlabeler) Unlabel(pr *github.PullRequest, reason string) {
+	if u == nil {
+		return
+	}
+
+	resp, err := u.client.RemoveLabelForIssue(context.Background(), u.owner, u.name, pr.GetNumber(), u.mergeLabel)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// the label was removed already, e.g. by a previous failure
+		return
+	}
+	if err != nil {
+		log.Printf("%s/%s: pr %d failed to remove label %q: %v\n", u.owner, u.name, pr.GetNumber(), u.mergeLabel, err)
+		return
+	}
+
+	if u.blockedLabel != "" {
+		if _, _, err := u.client.AddLabelsToIssue(context.Background(), u.owner, u.name, pr.GetNumber(), []string{u.blockedLabel}); err != nil {
+			log.Printf("%s/%s: pr %d failed to add label %q: %v\n", u.owner, u.name, pr.GetNumber(), u.blockedLabel, err)
+		} else {
+			u.mu.Lock()
+			u.blocked[pr.GetNumber()] = true
+			u.mu.Unlock()
+		}
+	}
+
+	body := fmt.Sprintf("@%s rebase-bot removed the `%s` label: **%s**.\n\nAdd the label again once this is resolved to retry.", pr.User.GetLogin(), u.mergeLabel, reason)
+	if _, _, err := u.client.CreateComment(context.Background(), u.owner, u.name, pr.GetNumber(), &github.IssueComment{Body: &body}); err != nil {
+		log.Printf("%s/%s: pr %d failed to notify author: %v\n", u.owner, u.name, pr.GetNumber(), err)
+	}
+}
+
+// Unblock removes the blocked label of pull requests which passed verification again
+func (u *unlabeler) Unblock(input <-chan *github.PullRequest) <-chan *github.PullRequest {
+	if u == nil || u.blockedLabel == "" {
+		return input
+	}
+
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for pr := range input {
+			u.mu.Lock()
+			blocked := u.blocked[pr.GetNumber()]
+			delete(u.blocked, pr.GetNumber())
+			u.mu.Unlock()
+
+			if blocked {
+				if _, err := u.client.RemoveLabelForIssue(context.Background(), u.owner, u.name, pr.GetNumber(), u.blockedLabel); err != nil {
+					log.Printf("%s/%s: pr %d failed to remove label %q: %v\n", u.owner, u.name, pr.GetNumber(), u.blockedLabel, err)
+				}
+			}
+			ret <- pr
+		}
+		close(ret)
+	}()
+	return ret
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+type fakeLabelClient struct {
+	labels   map[string]bool
+	comments []string
+}
+
+func (f *fakeLabelClient) RemoveLabelForIssue(_ context.Context, _, _ string, _ int, label string) (*github.Response, error) {
+	if !f.labels[label] {
+		resp := &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+		return resp, &github.ErrorResponse{Response: resp.Response}
+	}
+	delete(f.labels, label)
+	return nil, nil
+}
+
+func (f *fakeLabelClient) AddLabelsToIssue(_ context.Context, _, _ string, _ int, labels []string) ([]*github.Label, *github.Response, error) {
+	for _, label := range labels {
+		f.labels[label] = true
+	}
+	return nil, nil, nil
+}
+
+func (f *fakeLabelClient) CreateComment(_ context.Context, _, _ string, _ int, c *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	f.comments = append(f.comments, c.GetBody())
+	return c, nil, nil
+}
+
+func blockedPullRequest() *github.PullRequest {
+	return &github.PullRequest{
+		Number: intVal(1),
+		User:   &github.User{Login: stringVal("author")},
+	}
+}
+
+func TestUnlabeler(t *testing.T) {
+	t.Run("swaps the merge label", func(t *testing.T) {
+		client := &fakeLabelClient{labels: map[string]bool{"LGTM": true}}
+		u := newUnlabeler("test", "test", "LGTM", defaultBlockedLabel, client)
+
+		u.Unlabel(blockedPullRequest(), "is not mergeable")
+		u.Unlabel(blockedPullRequest(), "is not mergeable")
+
+		if client.labels["LGTM"] || !client.labels[defaultBlockedLabel] {
+			t.Fatalf("Expected the merge label to be swapped, but got %v", client.labels)
+		}
+		if len(client.comments) != 1 || !strings.HasPrefix(client.comments[0], "@author") {
+			t.Fatalf("Expected the author to be notified once, but got %v", client.comments)
+		}
+
+		// re-labeled pull requests which pass verification are unblocked
+		client.labels["LGTM"] = true
+		ch := make(chan *github.PullRequest, 1)
+		ch <- blockedPullRequest()
+		close(ch)
+		for range u.Unblock(ch) {
+		}
+		if client.labels[defaultBlockedLabel] {
+			t.Fatalf("Expected the blocked label to be removed, but got %v", client.labels)
+		}
+	})
+
+	t.Run("only removes the merge label", func(t *testing.T) {
+		client := &fakeLabelClient{labels: map[string]bool{"LGTM": true}}
+		u := newUnlabeler("test", "test", "LGTM", "", client)
+
+		u.Unlabel(blockedPullRequest(), "is not mergeable")
+		if len(client.labels) != 0 {
+			t.Fatalf("Expected no labels, but got %v", client.labels)
+		}
+	})
+
+	t.Run("nil unlabelers keep labels", func(t *testing.T) {
+		var u *unlabeler
+		u.Unlabel(blockedPullRequest(), "is not mergeable")
+		ch := make(chan *github.PullRequest)
+		if u.Unblock(ch) != (<-chan *github.PullRequest)(ch) {
+			t.Fatal("Expected the input to be passed through")
+		}
+	})
+}
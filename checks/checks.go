@@ -0,0 +1,210 @@
+// Package checks implements the parts of the GitHub Checks API used by the bot.
+// The vendored go-github predates check runs and check suites.
+package checks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+const mediaTypeChecksPreview = "application/vnd.github.antiope-preview+json"
+
+// CheckRun is a single check reported by an integration, e.g. GitHub Actions
+type CheckRun struct {
+	ID         *int64  `json:"id,omitempty"`
+	Name       *string `json:"name,omitempty"`
+	HeadSHA    *string `json:"head_sha,omitempty"`
+	Status     *string `json:"status,omitempty"`
+	Conclusion *string `json:"conclusion,omitempty"`
+	// CheckSuite is the suite the run belongs to, telling the branch it ran on
+	CheckSuite *CheckSuite `json:"check_suite,omitempty"`
+
+	PullRequests []*PullRequest `json:"pull_requests,omitempty"`
+}
+
+// CheckSuite groups all check runs of an integration for a commit
+type CheckSuite struct {
+	ID         *int64  `json:"id,omitempty"`
+	HeadBranch *string `json:"head_branch,omitempty"`
+	HeadSHA    *string `json:"head_sha,omitempty"`
+	Status     *string `json:"status,omitempty"`
+	Conclusion *string `json:"conclusion,omitempty"`
+
+	PullRequests []*PullRequest `json:"pull_requests,omitempty"`
+}
+
+// PullRequest is the minimal pull request reference attached to check runs and suites
+type PullRequest struct {
+	Number *int `json:"number,omitempty"`
+}
+
+// CheckRunEvent is triggered when a check run is created, completed or rerequested
+type CheckRunEvent struct {
+	Action   *string            `json:"action,omitempty"`
+	CheckRun *CheckRun          `json:"check_run,omitempty"`
+	Repo     *github.Repository `json:"repository,omitempty"`
+}
+
+// CheckSuiteEvent is triggered when a check suite is completed, requested or rerequested
+type CheckSuiteEvent struct {
+	Action     *string            `json:"action,omitempty"`
+	CheckSuite *CheckSuite        `json:"check_suite,omitempty"`
+	Repo       *github.Repository `json:"repository,omitempty"`
+}
+
+// GetID returns the ID field if it's non-nil, zero value otherwise
+func (c *CheckRun) GetID() int64 {
+	if c == nil || c.ID == nil {
+		return 0
+	}
+	return *c.ID
+}
+
+// GetName returns the Name field if it's non-nil, zero value otherwise
+func (c *CheckRun) GetName() string {
+	if c == nil || c.Name == nil {
+		return ""
+	}
+	return *c.Name
+}
+
+// GetHeadSHA returns the HeadSHA field if it's non-nil, zero value otherwise
+func (c *CheckRun) GetHeadSHA() string {
+	if c == nil || c.HeadSHA == nil {
+		return ""
+	}
+	return *c.HeadSHA
+}
+
+// GetStatus returns the Status field if it's non-nil, zero value otherwise
+func (c *CheckRun) GetStatus() string {
+	if c == nil || c.Status == nil {
+		return ""
+	}
+	return *c.Status
+}
+
+// GetConclusion returns the Conclusion field if it's non-nil, zero value otherwise
+func (c *CheckRun) GetConclusion() string {
+	if c == nil || c.Conclusion == nil {
+		return ""
+	}
+	return *c.Conclusion
+}
+
+// GetCheckSuite returns the CheckSuite field
+func (c *CheckRun) GetCheckSuite() *CheckSuite {
+	if c == nil {
+		return nil
+	}
+	return c.CheckSuite
+}
+
+// GetHeadBranch returns the HeadBranch field if it's non-nil, zero value otherwise
+func (c *CheckSuite) GetHeadBranch() string {
+	if c == nil || c.HeadBranch == nil {
+		return ""
+	}
+	return *c.HeadBranch
+}
+
+// GetHeadSHA returns the HeadSHA field if it's non-nil, zero value otherwise
+func (c *CheckSuite) GetHeadSHA() string {
+	if c == nil || c.HeadSHA == nil {
+		return ""
+	}
+	return *c.HeadSHA
+}
+
+// GetStatus returns the Status field if it's non-nil, zero value otherwise
+func (c *CheckSuite) GetStatus() string {
+	if c == nil || c.Status == nil {
+		return ""
+	}
+	return *c.Status
+}
+
+// GetConclusion returns the Conclusion field if it's non-nil, zero value otherwise
+func (c *CheckSuite) GetConclusion() string {
+	if c == nil || c.Conclusion == nil {
+		return ""
+	}
+	return *c.Conclusion
+}
+
+// GetAction returns the Action field if it's non-nil, zero value otherwise
+func (e *CheckRunEvent) GetAction() string {
+	if e == nil || e.Action == nil {
+		return ""
+	}
+	return *e.Action
+}
+
+// GetAction returns the Action field if it's non-nil, zero value otherwise
+func (e *CheckSuiteEvent) GetAction() string {
+	if e == nil || e.Action == nil {
+		return ""
+	}
+	return *e.Action
+}
+
+// State reduces the conclusions of check runs to a commit status state:
+// pending while any run is not completed, failure if any run did not succeed
+// and success otherwise
+func State(runs []*CheckRun) string {
+	state := "success"
+	for _, run := range runs {
+		if run.GetStatus() != "completed" {
+			state = "pending"
+			continue
+		}
+		switch run.GetConclusion() {
+		case "success", "neutral", "skipped":
+		default:
+			return "failure"
+		}
+	}
+	return state
+}
+
+// Service talks to the checks endpoints of the GitHub API
+type Service struct {
+	client *github.Client
+}
+
+// NewService returns a checks service using the given client for requests
+func NewService(client *github.Client) *Service {
+	return &Service{client: client}
+}
+
+type listCheckRunsResult struct {
+	TotalCount int         `json:"total_count"`
+	CheckRuns  []*CheckRun `json:"check_runs"`
+}
+
+// ListCheckRunsForRef lists all check runs of a sha, branch or tag
+func (s *Service) ListCheckRunsForRef(ctx context.Context, owner, repo, ref string) ([]*CheckRun, *github.Response, error) {
+	var runs []*CheckRun
+	page := 1
+	for {
+		u := fmt.Sprintf("repos/%s/%s/commits/%s/check-runs?per_page=100&page=%d", owner, repo, ref, page)
+		req, err := s.client.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Accept", mediaTypeChecksPreview)
+
+		result := new(listCheckRunsResult)
+		resp, err := s.client.Do(ctx, req, result)
+		if err != nil {
+			return nil, resp, err
+		}
+		runs = append(runs, result.CheckRuns...)
+		if resp.NextPage == 0 {
+			return runs, resp, nil
+		}
+		page = resp.NextPage
+	}
+}
@@ -0,0 +1,88 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func stringVal(s string) *string {
+	return &s
+}
+
+func TestState(t *testing.T) {
+	for name, tc := range map[string]struct {
+		runs     []*CheckRun
+		expected string
+	}{
+		"no runs": {
+			expected: "success",
+		},
+		"successful runs": {
+			runs: []*CheckRun{
+				{Status: stringVal("completed"), Conclusion: stringVal("success")},
+				{Status: stringVal("completed"), Conclusion: stringVal("neutral")},
+				{Status: stringVal("completed"), Conclusion: stringVal("skipped")},
+			},
+			expected: "success",
+		},
+		"running runs": {
+			runs: []*CheckRun{
+				{Status: stringVal("completed"), Conclusion: stringVal("success")},
+				{Status: stringVal("in_progress")},
+			},
+			expected: "pending",
+		},
+		"failed runs": {
+			runs: []*CheckRun{
+				{Status: stringVal("queued")},
+				{Status: stringVal("completed"), Conclusion: stringVal("timed_out")},
+			},
+			expected: "failure",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if state := State(tc.runs); state != tc.expected {
+				t.Fatalf("Expected %q, but got %q", tc.expected, state)
+			}
+		})
+	}
+}
+
+func TestService_ListCheckRunsForRef(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/test/test/commits/abc/check-runs" {
+			t.Errorf("Unexpected path %q", r.URL.Path)
+		}
+		if accept := r.Header.Get("Accept"); accept != mediaTypeChecksPreview {
+			t.Errorf("Unexpected accept header %q", accept)
+		}
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, srv.URL, r.URL.Path))
+			fmt.Fprint(w, `{"total_count": 2, "check_runs": [{"name": "build", "status": "completed", "conclusion": "success"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"total_count": 2, "check_runs": [{"name": "test", "status": "in_progress"}]}`)
+	}))
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	runs, _, err := NewService(client).ListCheckRunsForRef(context.Background(), "test", "test", "abc")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(runs) != 2 || runs[0].GetName() != "build" || runs[1].GetName() != "test" {
+		t.Fatalf("Unexpected check runs %v", runs)
+	}
+	if state := State(runs); state != "pending" {
+		t.Fatalf("Expected pending, but got %q", state)
+	}
+}
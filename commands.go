@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// command is a slash command given in a pull request comment, e.g. /priority high
+type command struct {
+	name string
+	args []string
+}
+
+// commandPermissions lists the repository permission a commenter needs for every command
+var commandPermissions = map[string]string{
+	"rebase":   "write",
+	"merge":    "write",
+	"cancel":   "write",
+	"retry":    "write",
+	"priority": "write",
+	"status":   "read",
+}
+
+// permissionRanks orders repository permission levels
+var permissionRanks = map[string]int{
+	"none":  0,
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
+// parseCommand reads a command from the first line of a comment
+func parseCommand(body string) (command, bool) {
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(body), "\n", 2)[0])
+	if !strings.HasPrefix(line, "/") {
+		return command{}, false
+	}
+	fields := strings.Fields(line[1:])
+	if len(fields) == 0 {
+		return command{}, false
+	}
+	name := strings.ToLower(fields[0])
+	if _, ok := commandPermissions[name]; !ok {
+		return command{}, false
+	}
+	return command{name: name, args: fields[1:]}, true
+}
+
+// PermissionGetter looks up the permission of a user on a repository
+type PermissionGetter interface {
+	GetPermissionLevel(context.Context, string, string, string) (*github.RepositoryPermissionLevel, *github.Response, error)
+}
+
+// ReactionCreator reacts to issue comments
+type ReactionCreator interface {
+	CreateIssueCommentReaction(context.Context, string, string, int, string) (*github.Reaction, *github.Response, error)
+}
+
+// commander executes commands given in pull request comments. Commands are
+// acknowledged with a reaction, or a reply if there's something to explain
+type commander struct {
+	owner      string
+	name       string
+	mergeLabel string
+
+	pulls       PullRequestGetter
+	permissions PermissionGetter
+	reactions   ReactionCreator
+	labels      LabelClient
+
+	// enqueue verifies a pull request again
+	enqueue func(*github.PullRequest) bool
+	// rebase rebases a pull request without merging it
+	rebase func(*github.PullRequest) bool
+	// prioritize moves a pull request to the front of the queue. It reports false
+	// if pull requests are not queued
+	prioritize func(*github.PullRequest) bool
+	// status describes what the bot is doing with a pull request
+	status func(*github.PullRequest) string
+}
+
+// Run executes the commands of all comments
+func (c *commander) Run(input <-chan *github.IssueCommentEvent) {
+	for evt := range input {
+		if evt.GetAction() != "created" || evt.Issue.PullRequestLinks == nil {
+			continue
+		}
+		cmd, ok := parseCommand(evt.Comment.GetBody())
+		if !ok {
+			continue
+		}
+		c.execute(evt, cmd)
+	}
+}
+
+// execute checks the permission of the commenter and executes a command
+func (c *commander) execute(evt *github.IssueCommentEvent, cmd command) {
+	number := evt.Issue.GetNumber()
+	login := evt.Comment.User.GetLogin()
+	log.Printf("%s/%s: pr %d received /%s from %s\n", c.owner, c.name, number, cmd.name, login)
+
+	level, _, err := c.permissions.GetPermissionLevel(context.Background(), c.owner, c.name, login)
+	if err != nil {
+		log.Printf("%s/%s: pr %d failed to lookup permission of %s: %v\n", c.owner, c.name, number, login, err)
+		c.react(evt, "confused")
+		return
+	}
+	required := commandPermissions[cmd.name]
+	if permissionRanks[level.GetPermission()] < permissionRanks[required] {
+		c.react(evt, "-1")
+		c.reply(evt, fmt.Sprintf("@%s `/%s` requires %s permission.", login, cmd.name, required))
+		return
+	}
+
+	pr, _, err := c.pulls.Get(context.Background(), c.owner, c.name, number)
+	if err != nil {
+		log.Printf("%s/%s: pr %d failed to lookup pull request: %v\n", c.owner, c.name, number, err)
+		c.react(evt, "confused")
+		return
+	}
+
+	switch cmd.name {
+	case "merge":
+		err = c.label(pr)
+	case "cancel":
+		resp, rerr := c.labels.RemoveLabelForIssue(context.Background(), c.owner, c.name, number, c.mergeLabel)
+		if rerr != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			err = rerr
+		} else {
+			// verifying the unlabeled pull request removes it from the queue
+			c.enqueue(pr)
+		}
+	case "retry":
+		if err = c.label(pr); err == nil {
+			c.enqueue(pr)
+		}
+	case "rebase":
+		c.rebase(pr)
+	case "priority":
+		if len(cmd.args) != 1 || !strings.EqualFold(cmd.args[0], "high") {
+			c.react(evt, "confused")
+			c.reply(evt, fmt.Sprintf("@%s usage: `/priority high`", login))
+			return
+		}
+		if !c.prioritize(pr) {
+			c.react(evt, "confused")
+			c.reply(evt, fmt.Sprintf("@%s pull requests are not queued in this repository, so they can't be prioritized.", login))
+			return
+		}
+	case "status":
+		c.reply(evt, fmt.Sprintf("@%s rebase-bot status of this pull request: %s", login, c.status(pr)))
+	}
+
+	if err != nil {
+		log.Printf("%s/%s: pr %d failed to execute /%s: %v\n", c.owner, c.name, number, cmd.name, err)
+		c.react(evt, "confused")
+		return
+	}
+	c.react(evt, "+1")
+}
+
+// label adds the merge label to a pull request
+func (c *commander) label(pr *github.PullRequest) error {
+	_, _, err := c.labels.AddLabelsToIssue(context.Background(), c.owner, c.name, pr.GetNumber(), []string{c.mergeLabel})
+	return err
+}
+
+func (c *commander) react(evt *github.IssueCommentEvent, content string) {
+	if _, _, err := c.reactions.CreateIssueCommentReaction(context.Background(), c.owner, c.name, evt.Comment.GetID(), content); err != nil {
+		log.Printf("%s/%s: pr %d failed to react to comment: %v\n", c.owner, c.name, evt.Issue.GetNumber(), err)
+	}
+}
+
+func (c *commander) reply(evt *github.IssueCommentEvent, body string) {
+	if _, _, err := c.labels.CreateComment(context.Background(), c.owner, c.name, evt.Issue.GetNumber(), &github.IssueComment{Body: &body}); err != nil {
+		log.Printf("%s/%s: pr %d failed to reply: %v\n", c.owner, c.name, evt.Issue.GetNumber(), err)
+	}
+}
+
+// statusBoard remembers what the bot last did with every pull request
+type statusBoard struct {
+	mu    sync.Mutex
+	views map[int]string
+}
+
+func newStatusBoard() *statusBoard {
+	return &statusBoard{views: map[int]string{}}
+}
+
+// Set records what the bot did with a pull request
+func (b *statusBoard) Set(pr *github.PullRequest, view string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.views[pr.GetNumber()] = view
+}
+
+// Get returns what the bot last did with a pull request
+func (b *statusBoard) Get(pr *github.PullRequest) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if view, ok := b.views[pr.GetNumber()]; ok {
+		return view
+	}
+	return "not evaluated yet"
+}
+
+// Remove forgets a pull request
+func (b *statusBoard) Remove(pr *github.PullRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.views, pr.GetNumber())
+}
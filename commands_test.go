@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestParseCommand(t *testing.T) {
+	for body, expected := range map[string]string{
+		"/merge":                      "merge",
+		"  /Priority high\nplease":    "priority high",
+		"/status please":              "status please",
+		"LGTM\n/merge":                "",
+		"/deploy":                     "",
+		"/":                           "",
+		"mentions /rebase in passing": "",
+	} {
+		cmd, ok := parseCommand(body)
+		got := ""
+		if ok {
+			got = strings.Join(append([]string{cmd.name}, cmd.args...), " ")
+		}
+		if got != expected {
+			t.Errorf("Expected %q to parse as %q, but got %q", body, expected, got)
+		}
+	}
+}
+
+type fakePermissionGetter map[string]string
+
+func (f fakePermissionGetter) GetPermissionLevel(_ context.Context, _, _, user string) (*github.RepositoryPermissionLevel, *github.Response, error) {
+	permission, ok := f[user]
+	if !ok {
+		permission = "none"
+	}
+	return &github.RepositoryPermissionLevel{Permission: stringVal(permission)}, nil, nil
+}
+
+type fakeReactionCreator struct {
+	reactions []string
+}
+
+func (f *fakeReactionCreator) CreateIssueCommentReaction(_ context.Context, _, _ string, _ int, content string) (*github.Reaction, *github.Response, error) {
+	f.reactions = append(f.reactions, content)
+	return nil, nil, nil
+}
+
+func commentEvent(login, body string) *github.IssueCommentEvent {
+	return &github.IssueCommentEvent{
+		Action: stringVal("created"),
+		Issue: &github.Issue{
+			Number:           intVal(1),
+			PullRequestLinks: &github.PullRequestLinks{},
+		},
+		Comment: &github.IssueComment{
+			ID:   intVal(1),
+			Body: stringVal(body),
+			User: &github.User{Login: stringVal(login)},
+		},
+	}
+}
+
+func TestCommander(t *testing.T) {
+	type recorder struct {
+		enqueued    []int
+		rebased     []int
+		prioritized []int
+	}
+	setup := func(queued bool) (*commander, *recorder, *fakeLabelClient, *fakeReactionCreator) {
+		rec := &recorder{}
+		labels := &fakeLabelClient{labels: map[string]bool{}}
+		reactions := &fakeReactionCreator{}
+		c := &commander{
+			owner:      "test",
+			name:       "test",
+			mergeLabel: "LGTM",
+			pulls: fakePullRequestGetter(func(number int) (*github.PullRequest, *github.Response, error) {
+				return &github.PullRequest{Number: intVal(number)}, nil, nil
+			}),
+			permissions: fakePermissionGetter{"maintainer": "write", "reader": "read"},
+			reactions:   reactions,
+			labels:      labels,
+			enqueue: func(pr *github.PullRequest) bool {
+				rec.enqueued = append(rec.enqueued, pr.GetNumber())
+				return true
+			},
+			rebase: func(pr *github.PullRequest) bool {
+				rec.rebased = append(rec.rebased, pr.GetNumber())
+				return true
+			},
+			prioritize: func(pr *github.PullRequest) bool {
+				rec.prioritized = append(rec.prioritized, pr.GetNumber())
+				return queued
+			},
+			status: func(*github.PullRequest) string {
+				return "queued, position 2 in the queue"
+			},
+		}
+		return c, rec, labels, reactions
+	}
+	run := func(c *commander, events ...*github.IssueCommentEvent) {
+		ch := make(chan *github.IssueCommentEvent, len(events))
+		for _, evt := range events {
+			ch <- evt
+		}
+		close(ch)
+		c.Run(ch)
+	}
+
+	t.Run("queues and cancels", func(t *testing.T) {
+		c, rec, labels, reactions := setup(true)
+		run(c, commentEvent("maintainer", "/merge"))
+		if !labels.labels["LGTM"] {
+			t.Fatal("Expected the merge label to be added")
+		}
+		run(c, commentEvent("maintainer", "/cancel"))
+		if labels.labels["LGTM"] || len(rec.enqueued) != 1 {
+			t.Fatalf("Expected the merge label to be removed and the pull request to be verified, but got %v and %v", labels.labels, rec.enqueued)
+		}
+		if len(reactions.reactions) != 2 || reactions.reactions[1] != "+1" {
+			t.Fatalf("Expected commands to be acknowledged, but got %v", reactions.reactions)
+		}
+	})
+
+	t.Run("retries and rebases", func(t *testing.T) {
+		c, rec, labels, _ := setup(true)
+		run(c, commentEvent("maintainer", "/retry"), commentEvent("maintainer", "/rebase"))
+		if !labels.labels["LGTM"] || len(rec.enqueued) != 1 || len(rec.rebased) != 1 {
+			t.Fatalf("Unexpected result %v, %+v", labels.labels, rec)
+		}
+	})
+
+	t.Run("checks permissions", func(t *testing.T) {
+		c, rec, labels, reactions := setup(true)
+		run(c, commentEvent("reader", "/merge"), commentEvent("stranger", "/status"))
+		if labels.labels["LGTM"] || len(rec.enqueued) != 0 {
+			t.Fatal("Expected commands to be denied")
+		}
+		if len(reactions.reactions) != 2 || reactions.reactions[0] != "-1" || reactions.reactions[1] != "-1" {
+			t.Fatalf("Expected commands to be rejected, but got %v", reactions.reactions)
+		}
+		if len(labels.comments) != 2 || labels.comments[0] != "@reader `/merge` requires write permission." {
+			t.Fatalf("Unexpected replies %v", labels.comments)
+		}
+	})
+
+	t.Run("replies with the status", func(t *testing.T) {
+		c, _, labels, _ := setup(true)
+		run(c, commentEvent("reader", "/status"))
+		if len(labels.comments) != 1 || !strings.Contains(labels.comments[0], "queued, position 2 in the queue") {
+			t.Fatalf("Unexpected replies %v", labels.comments)
+		}
+	})
+
+	t.Run("prioritizes queued pull requests", func(t *testing.T) {
+		c, rec, labels, reactions := setup(false)
+		run(c, commentEvent("maintainer", "/priority"), commentEvent("maintainer", "/priority high"))
+		if len(rec.prioritized) != 1 {
+			t.Fatalf("Expected a single prioritization, but got %v", rec.prioritized)
+		}
+		if len(labels.comments) != 2 || !strings.Contains(labels.comments[1], "can't be prioritized") {
+			t.Fatalf("Unexpected replies %v", labels.comments)
+		}
+		if len(reactions.reactions) != 2 || reactions.reactions[1] != "confused" {
+			t.Fatalf("Unexpected reactions %v", reactions.reactions)
+		}
+	})
+
+	t.Run("ignores comments on issues", func(t *testing.T) {
+		c, _, _, reactions := setup(true)
+		evt := commentEvent("maintainer", "/merge")
+		evt.Issue.PullRequestLinks = nil
+		run(c, evt)
+		if len(reactions.reactions) != 0 {
+			t.Fatalf("Expected no reactions, but got %v", reactions.reactions)
+		}
+	})
+}
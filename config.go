@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"github.com/nicolai86/github-rebase-bot/processors"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+const (
+	// mergeModeParallel rebases every verified pull request at once
+	mergeModeParallel = "parallel"
+	// mergeModeSerial rebases and merges one pull request at a time
+	mergeModeSerial = "serial"
+	// mergeModeBatch tests several pull requests together on a staging branch
+	mergeModeBatch = "batch"
+	// mergeModeRebase keeps labeled pull requests up to date with mainline without merging them
+	mergeModeRebase = "rebase"
+
+	defaultBatchSize     = 4
+	defaultStagingBranch = "rebase-bot/staging"
+)
+
+// config describes every repository handled by the bot
+type config struct {
+	Repositories []repositoryConfig `json:"repositories"`
+}
+
+// repositoryConfig describes a single repository
+type repositoryConfig struct {
+	// Repository is the full name of the repository, e.g. nicolai86/github-rebase-bot
+	Repository string `json:"repository"`
+	// Mainline is the branch pull requests are merged into. Defaults to master
+	Mainline string `json:"mainline,omitempty"`
+	// MergeLabel is the label which kicks off the merge process
+	MergeLabel string `json:"merge_label"`
+	// RebaseLabel marks pull requests which are kept up to date with mainline without merging them
+	RebaseLabel string `json:"rebase_label,omitempty"`
+	// MergeMethod is one of merge, squash or rebase. Defaults to merge
+	MergeMethod string `json:"merge_method,omitempty"`
+	SquashTitle string `json:"squash_title,omitempty"`
+	SquashBody  string `json:"squash_body,omitempty"`
+	// UpdateStrategy is one of rebase, merge or api and controls how branches are brought up
+	// to date with mainline. Defaults to rebase
+	UpdateStrategy string `json:"update_strategy,omitempty"`
+	// MergeMode is one of parallel, serial, batch or rebase. Defaults to parallel
+	MergeMode string `json:"merge_mode,omitempty"`
+	// BatchSize is the maximum number of pull requests tested together in batch mode. Defaults to 4
+	BatchSize int `json:"batch_size,omitempty"`
+	// StagingBranch is the branch batches are tested on. Defaults to rebase-bot/staging
+	StagingBranch string `json:"staging_branch,omitempty"`
+	// RequiredChecks lists the status contexts and check runs which must succeed before merging.
+	// Defaults to requiring every status and check run to succeed
+	RequiredChecks []string `json:"required_checks,omitempty"`
+	// RequiredChecksFromProtection reads the required checks from the branch protection of mainline.
+	// RequiredChecks are used if the protection can not be read
+	RequiredChecksFromProtection bool `json:"required_checks_from_protection,omitempty"`
+	// DeleteBranch controls if branches are deleted after merging. Defaults to true
+	DeleteBranch *bool `json:"delete_branch,omitempty"`
+	// Reviews describes the reviews required before merging. Defaults to none
+	Reviews  reviewPolicy     `json:"reviews"`
+	Feedback feedbackSettings `json:"feedback"`
+	Blocked  blockedSettings  `json:"blocked"`
+	Hook     hookSettings     `json:"hook"`
+	Commits  commitSettings   `json:"commits"`
+}
+
+// feedbackSettings describes how the bot explains what it's doing
+type feedbackSettings struct {
+	// Status reports a rebase-bot commit status on the head of pull requests. Defaults to true
+	Status *bool `json:"status,omitempty"`
+	// Comments explains why pull requests are blocked in a comment. Defaults to true
+	Comments *bool `json:"comments,omitempty"`
+}
+
+// blockedSettings describes what happens to pull requests which failed definitely,
+// e.g. because of a conflict or a failing required check
+type blockedSettings struct {
+	// Unlabel removes the merge label and notifies the author. Defaults to false
+	Unlabel bool `json:"unlabel,omitempty"`
+	// Label replaces the merge label when unlabeling. Defaults to rebase-bot:blocked,
+	// an empty string only removes the merge label
+	Label *string `json:"label,omitempty"`
+}
+
+// hookSettings describes how the webhook of a repository is managed
+type hookSettings struct {
+	// Register controls if the bot registers the webhook itself. Defaults to true
+	Register *bool `json:"register,omitempty"`
+	// Events the webhook subscribes to. Defaults to all events
+	Events []string `json:"events,omitempty"`
+	// Secret signs the payloads of the webhook. Defaults to -webhook-secret
+	Secret string `json:"secret,omitempty"`
+}
+
+// commitSettings describes the committer of commits created by the bot. Empty
+// fields default to the -git-* flags
+type commitSettings struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	// SigningKey is the path of a private key commits are signed with
+	SigningKey string `json:"signing_key,omitempty"`
+	// SigningFormat is one of gpg or ssh. Defaults to gpg
+	SigningFormat string `json:"signing_format,omitempty"`
+	// Provenance is one of committer or trailer and records the original commits
+	// of rebased commits. Defaults to none, making the bot the committer
+	Provenance string `json:"provenance,omitempty"`
+}
+
+// identity returns the committer of a repository
+func (s commitSettings) identity(defaults repo.Identity) repo.Identity {
+	id := defaults
+	if s.Name != "" {
+		id.Name = s.Name
+	}
+	if s.Email != "" {
+		id.Email = s.Email
+	}
+	if s.SigningKey != "" {
+		id.SigningKey = s.SigningKey
+		id.SigningFormat = repo.SigningFormat(s.SigningFormat)
+	}
+	if s.Provenance != "" {
+		id.Provenance = repo.Provenance(s.Provenance)
+	}
+	if id.SigningFormat == "" {
+		id.SigningFormat = repo.SigningGPG
+	}
+	return id
+}
+
+// loadConfig reads a JSON configuration file
+func loadConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c config
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			line, col := position(b, serr.Offset)
+			return nil, fmt.Errorf("%s:%d:%d: %v", path, line, col, err)
+		}
+		if terr, ok := err.(*json.UnmarshalTypeError); ok {
+			line, col := position(b, terr.Offset)
+			return nil, fmt.Errorf("%s:%d:%d: %s must be %s, got %s", path, line, col, terr.Field, terr.Type, terr.Value)
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// position converts a byte offset into line and column
+func position(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndex(before, []byte("\n"))
+	return line, col
+}
+
+// flagConfig builds a configuration from the -repos and -merge-label flags
+func flagConfig(rs repositories, mergeLabel string) *config {
+	c := config{}
+	for _, r := range rs {
+		c.Repositories = append(c.Repositories, repositoryConfig{
+			Repository:  fmt.Sprintf("%s/%s", r.Owner, r.Name),
+			Mainline:    r.Mainline,
+			MergeLabel:  mergeLabel,
+			MergeMethod: r.MergeMethod,
+		})
+	}
+	return &c
+}
+
+// validate checks the configuration and returns the first error found
+func (c *config) validate() error {
+	if len(c.Repositories) == 0 {
+		return fmt.Errorf("repositories: missing")
+	}
+
+	seen := map[string]int{}
+	for i, r := range c.Repositories {
+		field := func(name string) string {
+			return fmt.Sprintf("repositories[%d].%s", i, name)
+		}
+
+		parts := strings.Split(r.Repository, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("%s: invalid value %q, must be owner/name", field("repository"), r.Repository)
+		}
+		key := strings.ToLower(r.Repository)
+		if j, ok := seen[key]; ok {
+			return fmt.Errorf("%s: %q is already configured by repositories[%d]", field("repository"), r.Repository, j)
+		}
+		seen[key] = i
+
+		if strings.TrimSpace(r.MergeLabel) == "" {
+			return fmt.Errorf("%s: missing", field("merge_label"))
+		}
+		if r.MergeMethod != "" && !processors.ValidMergeMethod(r.MergeMethod) {
+			return fmt.Errorf("%s: invalid value %q, must be one of merge, squash, rebase", field("merge_method"), r.MergeMethod)
+		}
+		if r.UpdateStrategy != "" && !repo.ValidUpdateStrategy(r.UpdateStrategy) {
+			return fmt.Errorf("%s: invalid value %q, must be one of rebase, merge, api", field("update_strategy"), r.UpdateStrategy)
+		}
+		switch r.MergeMode {
+		case "", mergeModeParallel, mergeModeSerial, mergeModeBatch, mergeModeRebase:
+		default:
+			return fmt.Errorf("%s: invalid value %q, must be one of parallel, serial, batch, rebase", field("merge_mode"), r.MergeMode)
+		}
+		if r.RebaseLabel != "" && strings.EqualFold(r.RebaseLabel, r.MergeLabel) {
+			return fmt.Errorf("%s: must differ from merge_label %q", field("rebase_label"), r.MergeLabel)
+		}
+		if r.BatchSize < 0 {
+			return fmt.Errorf("%s: invalid value %d, must be positive", field("batch_size"), r.BatchSize)
+		}
+		mainline := r.Mainline
+		if mainline == "" {
+			mainline = "master"
+		}
+		if r.StagingBranch != "" && r.StagingBranch == mainline {
+			return fmt.Errorf("%s: must differ from mainline %q", field("staging_branch"), mainline)
+		}
+		// batches fast-forward mainline instead of merging pull requests one by one
+		if r.MergeMode == mergeModeBatch {
+			if r.MergeMethod != "" && r.MergeMethod != processors.MergeMethodMerge {
+				return fmt.Errorf("%s: %q is not supported in batch mode, which fast-forwards mainline", field("merge_method"), r.MergeMethod)
+			}
+			if r.SquashTitle != "" || r.SquashBody != "" {
+				return fmt.Errorf("%s: squash templates are not supported in batch mode, which fast-forwards mainline", field("merge_mode"))
+			}
+		}
+		if _, err := template.New("squash_title").Parse(r.SquashTitle); err != nil {
+			return fmt.Errorf("%s: %v", field("squash_title"), err)
+		}
+		if _, err := template.New("squash_body").Parse(r.SquashBody); err != nil {
+			return fmt.Errorf("%s: %v", field("squash_body"), err)
+		}
+
+		checks := map[string]bool{}
+		for j, check := range r.RequiredChecks {
+			if strings.TrimSpace(check) == "" {
+				return fmt.Errorf("%s[%d]: empty check name", field("required_checks"), j)
+			}
+			if checks[check] {
+				return fmt.Errorf("%s[%d]: duplicate check %q", field("required_checks"), j, check)
+			}
+			checks[check] = true
+		}
+
+		if r.Reviews.Approvals < 0 {
+			return fmt.Errorf("%s: invalid value %d, must be positive", field("reviews.approvals"), r.Reviews.Approvals)
+		}
+		for j, team := range r.Reviews.Teams {
+			parts := strings.Split(team, "/")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("%s[%d]: invalid value %q, must be org/team", field("reviews.teams"), j, team)
+			}
+		}
+
+		if r.Blocked.Label != nil && strings.EqualFold(*r.Blocked.Label, r.MergeLabel) {
+			return fmt.Errorf("%s: must differ from merge_label %q", field("blocked.label"), r.MergeLabel)
+		}
+
+		if r.Commits.SigningFormat != "" && !repo.ValidSigningFormat(r.Commits.SigningFormat) {
+			return fmt.Errorf("%s: invalid value %q, must be one of gpg, ssh", field("commits.signing_format"), r.Commits.SigningFormat)
+		}
+
+		if !repo.ValidProvenance(r.Commits.Provenance) {
+			return fmt.Errorf("%s: invalid value %q, must be one of committer, trailer", field("commits.provenance"), r.Commits.Provenance)
+		}
+
+		for j, event := range r.Hook.Events {
+			if strings.TrimSpace(event) == "" {
+				return fmt.Errorf("%s[%d]: empty event name", field("hook.events"), j)
+			}
+		}
+	}
+	return nil
+}
+
+// repositories converts a validated configuration into repositories
+func (c *config) repositories() repositories {
+	rs := repositories{}
+	for _, rc := range c.Repositories {
+		parts := strings.Split(rc.Repository, "/")
+
+		r := repository{
+			mergeLabel:      rc.MergeLabel,
+			rebaseLabel:     rc.RebaseLabel,
+			mergeMode:       rc.MergeMode,
+			updateStrategy:  repo.UpdateStrategy(rc.UpdateStrategy),
+			requiredChecks:  rc.RequiredChecks,
+			protectedChecks: rc.RequiredChecksFromProtection,
+			reviews:         rc.Reviews,
+			reportStatus:    rc.Feedback.Status == nil || *rc.Feedback.Status,
+			reportComments:  rc.Feedback.Comments == nil || *rc.Feedback.Comments,
+			unlabelBlocked:  rc.Blocked.Unlabel,
+			blockedLabel:    defaultBlockedLabel,
+			registerHook:    rc.Hook.Register == nil || *rc.Hook.Register,
+			hookEvents:      rc.Hook.Events,
+			config:          rc,
+		}
+		r.Owner = parts[0]
+		r.Name = parts[1]
+		r.Mainline = rc.Mainline
+		if r.Mainline == "" {
+			r.Mainline = "master"
+		}
+		r.MergeMethod = rc.MergeMethod
+		if r.MergeMethod == "" {
+			r.MergeMethod = processors.MergeMethodMerge
+		}
+		if r.updateStrategy == "" {
+			r.updateStrategy = repo.UpdateRebase
+		}
+		if r.mergeMode == "" {
+			r.mergeMode = mergeModeParallel
+		}
+		r.batchSize = rc.BatchSize
+		if r.batchSize == 0 {
+			r.batchSize = defaultBatchSize
+		}
+		r.stagingBranch = rc.StagingBranch
+		if r.stagingBranch == "" {
+			r.stagingBranch = defaultStagingBranch
+		}
+		if rc.Blocked.Label != nil {
+			r.blockedLabel = *rc.Blocked.Label
+		}
+		r.SquashTitle = rc.SquashTitle
+		r.SquashBody = rc.SquashBody
+		r.DeleteBranch = rc.DeleteBranch == nil || *rc.DeleteBranch
+		if len(r.hookEvents) == 0 {
+			r.hookEvents = []string{"*"}
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("parses repositories", func(t *testing.T) {
+		path := writeConfig(t, `{
+  "repositories": [
+    {
+      "repository": "test/test",
+      "mainline": "develop",
+      "merge_label": "LGTM",
+      "merge_method": "squash",
+      "required_checks": ["ci/test"],
+      "required_checks_from_protection": true,
+      "delete_branch": false,
+      "hook": {"register": false, "events": ["push", "status"]}
+    }
+  ]
+}`)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		c, err := loadConfig(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := c.validate(); err != nil {
+			t.Fatal(err.Error())
+		}
+		rs := c.repositories()
+		if len(rs) != 1 {
+			t.Fatalf("Expected 1 repository, but got %d", len(rs))
+		}
+		r := rs[0]
+		if r.Owner != "test" || r.Name != "test" || r.Mainline != "develop" || r.MergeMethod != "squash" {
+			t.Fatalf("Unexpected repository %s", r.String())
+		}
+		if r.mergeLabel != "LGTM" || len(r.requiredChecks) != 1 || !r.protectedChecks || r.DeleteBranch || r.registerHook || len(r.hookEvents) != 2 {
+			t.Fatalf("Unexpected repository settings %#v", r)
+		}
+	})
+
+	t.Run("reports syntax errors with position", func(t *testing.T) {
+		path := writeConfig(t, "{\n  \"repositories\": [\n    {\"repository\": \"test/test\",}\n  ]\n}")
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := loadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), "config.json:3:") {
+			t.Fatalf("Expected error with line number, but got %v", err)
+		}
+	})
+
+	t.Run("rejects unknown fields", func(t *testing.T) {
+		path := writeConfig(t, `{"repositories": [{"repository": "test/test", "merge_lable": "LGTM"}]}`)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := loadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), "merge_lable") {
+			t.Fatalf("Expected error on unknown field, but got %v", err)
+		}
+	})
+}
+
+func TestConfig_validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config   config
+		expected string
+	}{
+		"missing repositories": {
+			config:   config{},
+			expected: "repositories: missing",
+		},
+		"invalid repository": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test", MergeLabel: "LGTM"}}},
+			expected: `repositories[0].repository: invalid value "test", must be owner/name`,
+		},
+		"duplicate repository": {
+			config: config{Repositories: []repositoryConfig{
+				{Repository: "test/test", MergeLabel: "LGTM"},
+				{Repository: "Test/Test", MergeLabel: "LGTM"},
+			}},
+			expected: `repositories[1].repository: "Test/Test" is already configured by repositories[0]`,
+		},
+		"missing merge label": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test"}}},
+			expected: "repositories[0].merge_label: missing",
+		},
+		"invalid merge method": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", MergeMethod: "octopus"}}},
+			expected: `repositories[0].merge_method: invalid value "octopus", must be one of merge, squash, rebase`,
+		},
+		"invalid update strategy": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", UpdateStrategy: "squash"}}},
+			expected: `repositories[0].update_strategy: invalid value "squash", must be one of rebase, merge, api`,
+		},
+		"invalid signing format": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", Commits: commitSettings{SigningKey: "/keys/bot", SigningFormat: "x509"}}}},
+			expected: `repositories[0].commits.signing_format: invalid value "x509", must be one of gpg, ssh`,
+		},
+		"invalid provenance": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", Commits: commitSettings{Provenance: "notes"}}}},
+			expected: `repositories[0].commits.provenance: invalid value "notes", must be one of committer, trailer`,
+		},
+		"invalid merge mode": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", MergeMode: "random"}}},
+			expected: `repositories[0].merge_mode: invalid value "random", must be one of parallel, serial, batch, rebase`,
+		},
+		"rebase label is the merge label": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", RebaseLabel: "LGTM"}}},
+			expected: `repositories[0].rebase_label: must differ from merge_label "LGTM"`,
+		},
+		"invalid batch size": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", MergeMode: "batch", BatchSize: -1}}},
+			expected: "repositories[0].batch_size: invalid value -1, must be positive",
+		},
+		"staging on mainline": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", MergeMode: "batch", StagingBranch: "master"}}},
+			expected: `repositories[0].staging_branch: must differ from mainline "master"`,
+		},
+		"squash in batch mode": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", MergeMode: "batch", MergeMethod: "squash"}}},
+			expected: `repositories[0].merge_method: "squash" is not supported in batch mode, which fast-forwards mainline`,
+		},
+		"squash templates in batch mode": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", MergeMode: "batch", SquashTitle: "{{.Title}}"}}},
+			expected: "repositories[0].merge_mode: squash templates are not supported in batch mode, which fast-forwards mainline",
+		},
+		"invalid squash template": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", SquashTitle: "{{.Title"}}},
+			expected: "repositories[0].squash_title:",
+		},
+		"invalid review team": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", Reviews: reviewPolicy{Teams: []string{"reviewers"}}}}},
+			expected: `repositories[0].reviews.teams[0]: invalid value "reviewers", must be org/team`,
+		},
+		"blocked label is the merge label": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", Blocked: blockedSettings{Unlabel: true, Label: stringVal("lgtm")}}}},
+			expected: `repositories[0].blocked.label: must differ from merge_label "LGTM"`,
+		},
+		"duplicate required check": {
+			config:   config{Repositories: []repositoryConfig{{Repository: "test/test", MergeLabel: "LGTM", RequiredChecks: []string{"ci", "ci"}}}},
+			expected: `repositories[0].required_checks[1]: duplicate check "ci"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.validate()
+			if err == nil || !strings.HasPrefix(err.Error(), tc.expected) {
+				t.Fatalf("Expected error %q, but got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestFlagConfig(t *testing.T) {
+	var rs repositories
+	if err := rs.Set("test/a#develop:squash,test/b"); err != nil {
+		t.Fatal(err.Error())
+	}
+	c := flagConfig(rs, "LGTM")
+	if err := c.validate(); err != nil {
+		t.Fatal(err.Error())
+	}
+	converted := c.repositories()
+	if len(converted) != 2 {
+		t.Fatalf("Expected 2 repositories, but got %d", len(converted))
+	}
+	if converted[0].String() != rs[0].String() || converted[1].String() != rs[1].String() {
+		t.Fatalf("Expected %v, but got %v", rs, converted)
+	}
+	for _, r := range converted {
+		if r.mergeLabel != "LGTM" || !r.DeleteBranch || !r.registerHook {
+			t.Fatalf("Unexpected repository settings %#v", r)
+		}
+	}
+}
+
+func TestCommitSettings_identity(t *testing.T) {
+	defaults := repo.Identity{Name: "rebase bot", Email: "bot@example.com", SigningKey: "/keys/gpg", SigningFormat: repo.SigningGPG}
+
+	if id := (commitSettings{}).identity(defaults); id != defaults {
+		t.Fatalf("Expected the defaults, but got %v", id)
+	}
+	id := commitSettings{Email: "team@example.com", SigningKey: "/keys/ssh", SigningFormat: "ssh", Provenance: "trailer"}.identity(defaults)
+	expected := repo.Identity{Name: "rebase bot", Email: "team@example.com", SigningKey: "/keys/ssh", SigningFormat: repo.SigningSSH, Provenance: repo.ProvenanceTrailer}
+	if id != expected {
+		t.Fatalf("Expected %v, but got %v", expected, id)
+	}
+}
@@ -6,12 +6,20 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
+	"github.com/nicolai86/github-rebase-bot/journal"
 	"github.com/nicolai86/github-rebase-bot/processors"
 	"github.com/nicolai86/github-rebase-bot/repo"
 )
 
+// rebaseRetryDelay is how long pull requests which failed to rebase for reasons
+// other than a conflict wait before they are retried
+const rebaseRetryDelay = time.Minute
+
 type statusEventBroadcaster struct {
 	listeners []chan<- *github.StatusEvent
 }
@@ -28,14 +36,232 @@ func (b *statusEventBroadcaster) Listen(in <-chan *github.StatusEvent) {
 	}
 }
 
-func prHandler(r repository, client *github.Client) http.HandlerFunc {
-	issueQueue := make(chan *github.IssuesEvent, 100)
-	prQueue := make(chan *github.PullRequest, 100)
-	reviewQueue := make(chan *github.PullRequestReviewEvent, 100)
-	pushEventQueue := make(chan *github.PushEvent, 100)
-	statusEventQueue := make(chan *github.StatusEvent, 100)
+// PullRequestGetter queries github for a specific pull request
+type PullRequestGetter interface {
+	Get(context.Context, string, string, int) (*github.PullRequest, *github.Response, error)
+}
+
+// recordStage passes pull requests through while recording their stage
+func recordStage(r processors.Repository, stage journal.Stage, input <-chan *github.PullRequest) <-chan *github.PullRequest {
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for pr := range input {
+			r.Record(pr, stage)
+			ret <- pr
+		}
+		close(ret)
+	}()
+	return ret
+}
+
+// isStale reports if a branch changed on github while the bot updated it
+func isStale(err error) bool {
+	_, ok := err.(*repo.StaleBranchError)
+	return ok
+}
+
+// isConflict reports if a branch could not be rebased because of a conflict
+func isConflict(err error) bool {
+	_, ok := err.(*repo.RebaseConflictError)
+	return ok
+}
+
+// isRetryable reports if a branch could not be updated because mainline could not be fetched
+func isRetryable(err error) bool {
+	_, ok := processors.Retryable(err)
+	return ok
+}
+
+// tap passes pull requests through while calling fn for each of them
+func tap(input <-chan *github.PullRequest, fn func(*github.PullRequest)) <-chan *github.PullRequest {
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for pr := range input {
+			fn(pr)
+			ret <- pr
+		}
+		close(ret)
+	}()
+	return ret
+}
+
+// stagingEvents collects the shas of completed statuses, check runs and check
+// suites, so batches re-evaluate their staging branch. Check events are passed through
+func stagingEvents(statuses <-chan *github.StatusEvent, runs <-chan *checks.CheckRunEvent, suites <-chan *checks.CheckSuiteEvent) (<-chan string, <-chan *checks.CheckRunEvent, <-chan *checks.CheckSuiteEvent) {
+	shas := make(chan string, 100)
+	retRuns := make(chan *checks.CheckRunEvent)
+	retSuites := make(chan *checks.CheckSuiteEvent)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		for evt := range statuses {
+			if evt.GetState() != "pending" && evt.GetContext() != statusContext {
+				shas <- evt.GetSHA()
+			}
+		}
+		wg.Done()
+	}()
+	go func() {
+		for evt := range runs {
+			if evt.GetAction() == "completed" {
+				shas <- evt.CheckRun.GetHeadSHA()
+			}
+			retRuns <- evt
+		}
+		close(retRuns)
+		wg.Done()
+	}()
+	go func() {
+		for evt := range suites {
+			if evt.GetAction() == "completed" {
+				shas <- evt.CheckSuite.GetHeadSHA()
+			}
+			retSuites <- evt
+		}
+		close(retSuites)
+		wg.Done()
+	}()
+	go func() {
+		wg.Wait()
+		close(shas)
+	}()
+	return shas, retRuns, retSuites
+}
+
+// pipeline processes all events of a single repository
+type pipeline struct {
+	r repository
+
+	mu      sync.RWMutex
+	stopped bool
+	done    chan struct{}
+	// quit is closed when stopping, releasing senders blocked on full queues
+	quit     chan struct{}
+	quitOnce sync.Once
+
+	issueQueue       chan *github.IssuesEvent
+	prQueue          chan *github.PullRequest
+	reviewQueue      chan *github.PullRequestReviewEvent
+	pushEventQueue   chan *github.PushEvent
+	statusEventQueue chan *github.StatusEvent
+	checkRunQueue    chan *checks.CheckRunEvent
+	checkSuiteQueue  chan *checks.CheckSuiteEvent
+	commentQueue     chan *github.IssueCommentEvent
+	// rebaseQueue contains pull requests which are rebased without merging them
+	rebaseQueue chan *github.PullRequest
+}
+
+// enqueue adds a pull request to the pipeline unless it was stopped.
+// Sends give up once the pipeline stops, so Stop never waits for a full queue
+func (p *pipeline) enqueue(pr *github.PullRequest) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return false
+	}
+	select {
+	case p.prQueue <- pr:
+		return true
+	case <-p.quit:
+		return false
+	}
+}
+
+// rebase rebases a pull request without merging it unless the pipeline was stopped
+func (p *pipeline) rebase(pr *github.PullRequest) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return false
+	}
+	select {
+	case p.rebaseQueue <- pr:
+		return true
+	case <-p.quit:
+		return false
+	}
+}
+
+// Stop stops accepting new events and blocks until all queued pull requests,
+// including in-flight rebases, are processed
+func (p *pipeline) Stop() {
+	// release blocked senders before waiting for the write lock, otherwise
+	// readers queued behind it keep the pipeline from draining
+	p.quitOnce.Do(func() { close(p.quit) })
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.issueQueue)
+		close(p.prQueue)
+		close(p.reviewQueue)
+		close(p.pushEventQueue)
+		close(p.statusEventQueue)
+		close(p.checkRunQueue)
+		close(p.checkSuiteQueue)
+		close(p.commentQueue)
+		close(p.rebaseQueue)
+	}
+	p.mu.Unlock()
+	<-p.done
+}
+
+// resume looks up pull requests recorded in the journal and sorts them by the
+// stage they were in. Closed pull requests are removed from the journal
+func resume(r repository, client PullRequestGetter, entries []journal.Entry) (rebase, merge, verify []*github.PullRequest) {
+	for _, e := range entries {
+		pr, _, err := client.Get(context.Background(), r.Owner, r.Name, e.Number)
+		if err != nil {
+			log.Printf("%s/%s: failed to resume pr %d: %v\n", r.Owner, r.Name, e.Number, err)
+			continue
+		}
+		if pr.GetState() != "open" {
+			r.Forget(pr)
+			continue
+		}
+
+		log.Printf("%s/%s: resuming pr %d from stage %s\n", r.Owner, r.Name, e.Number, e.Stage)
+		switch e.Stage {
+		case journal.StageVerified, journal.StageRebasing:
+			rebase = append(rebase, pr)
+		case journal.StageMerging:
+			merge = append(merge, pr)
+		default:
+			// pushed pull requests wait for CI, which might have finished in the meantime
+			verify = append(verify, pr)
+		}
+	}
+	return rebase, merge, verify
+}
+
+// boardTrain adds pull requests which were in flight before a restart to a
+// merge train, so they stay ahead of other pull requests once verified again
+func boardTrain(train *processors.Train, resumed ...[]*github.PullRequest) {
+	for _, prs := range resumed {
+		for _, pr := range prs {
+			train.Add(pr)
+		}
+	}
+}
+
+func prHandler(r repository, client *github.Client, entries []journal.Entry) *pipeline {
+	p := &pipeline{
+		r:                r,
+		done:             make(chan struct{}),
+		quit:             make(chan struct{}),
+		issueQueue:       make(chan *github.IssuesEvent, 100),
+		prQueue:          make(chan *github.PullRequest, 100),
+		reviewQueue:      make(chan *github.PullRequestReviewEvent, 100),
+		pushEventQueue:   make(chan *github.PushEvent, 100),
+		statusEventQueue: make(chan *github.StatusEvent, 100),
+		checkRunQueue:    make(chan *checks.CheckRunEvent, 100),
+		checkSuiteQueue:  make(chan *checks.CheckSuiteEvent, 100),
+		commentQueue:     make(chan *github.IssueCommentEvent, 100),
+		rebaseQueue:      make(chan *github.PullRequest, 100),
+	}
 	statusPRQueue := make(chan *github.StatusEvent, 100)
 	mainlineStatusEventQueue := make(chan *github.StatusEvent, 100)
+	stagingStatusEventQueue := make(chan *github.StatusEvent, 100)
 
 	statusBroadcaster := statusEventBroadcaster{
 		listeners: []chan<- *github.StatusEvent{
@@ -43,21 +269,166 @@ func prHandler(r repository, client *github.Client) http.HandlerFunc {
 			mainlineStatusEventQueue,
 		},
 	}
-	go statusBroadcaster.Listen(statusEventQueue)
+	if r.mergeMode == mergeModeBatch {
+		statusBroadcaster.listeners = append(statusBroadcaster.listeners, stagingStatusEventQueue)
+	}
+	go statusBroadcaster.Listen(p.statusEventQueue)
+
+	// the reporter explains the progress of pull requests on github
+	var rep *reporter
+	if r.reportStatus || r.reportComments {
+		rep = newReporter(r.Owner, r.Name, r.Mainline, nil, nil)
+		if r.reportStatus {
+			rep.statuses = client.Repositories
+		}
+		if r.reportComments {
+			rep.comments = client.Issues
+		}
+		r.Journal = stageReporter{journal: r.Journal, reporter: rep}
+	}
+
+	// the unlabeler takes pull requests which failed definitely out of the queue
+	var unlabel *unlabeler
+	if r.unlabelBlocked {
+		unlabel = newUnlabeler(r.Owner, r.Name, r.mergeLabel, r.blockedLabel, client.Issues)
+	}
+
+	// the board remembers what the bot did with every pull request for /status
+	board := newStatusBoard()
+
+	// pull requests from forks which don't allow edits by maintainers can't be rebased
+	forks := newForkGuard(r.Repository, client.Issues)
+
+	// resumed PRs are verified again before continuing, their labels, statuses
+	// or reviews might have changed while the bot was down
+	resumeRebase, resumeMerge, resumeVerify := resume(r, client.PullRequests, entries)
+	resumed := append(append(resumeVerify, resumeMerge...), resumeRebase...)
+
+	required := staticChecks(r.requiredChecks)
+	if r.protectedChecks {
+		required = protectedChecks(client.Repositories, r.Owner, r.Name, r.Mainline, r.requiredChecks, 5*time.Minute)
+	}
+
+	// in batch mode PRs are merged by fast-forwarding mainline to a green staging branch.
+	// The staging branch is evaluated like pull requests, by its statuses and check runs
+	var batch *processors.Batch
+	checkRunQueue, checkSuiteQueue := (<-chan *checks.CheckRunEvent)(p.checkRunQueue), (<-chan *checks.CheckSuiteEvent)(p.checkSuiteQueue)
+	var stagingQueue <-chan string
+	if r.mergeMode == mergeModeBatch {
+		staging := commitChecker{
+			owner:    r.Owner,
+			name:     r.Name,
+			statuses: client.Repositories,
+			runs:     checks.NewService(client),
+			required: required,
+		}
+		stagingQueue, checkRunQueue, checkSuiteQueue = stagingEvents(stagingStatusEventQueue, checkRunQueue, checkSuiteQueue)
+		batch = processors.NewBatch(r.Repository, r.Cache.(processors.Stager), staging, r.stagingBranch, r.batchSize)
+		batch.OnEject = func(pr *github.PullRequest, err error) {
+			r.Forget(pr)
+			rep.Block(pr, err.Error())
+			unlabel.Unlabel(pr, err.Error())
+			board.Set(pr, fmt.Sprintf("blocked: %v", err))
+		}
+	}
+
+	// pull requests labeled with rebaseLabel are kept up to date with mainline without
+	// merging them. In rebase mode the merge label does the same and nothing is merged
+	rebaseLabels, mergeLabel := []string{}, r.mergeLabel
+	if r.rebaseLabel != "" {
+		rebaseLabels = append(rebaseLabels, r.rebaseLabel)
+	}
+	if r.mergeMode == mergeModeRebase {
+		rebaseLabels, mergeLabel = append(rebaseLabels, r.mergeLabel), ""
+	}
+
+	// in serial mode only the head of the merge train is rebased and merged
+	var train *processors.Train
+	if r.mergeMode == mergeModeSerial {
+		train = processors.NewTrain()
+		boardTrain(train, resumeMerge, resumeRebase)
+	}
+	// advance removes a pull request from the merge train and re-evaluates the next one
+	advance := func(pr *github.PullRequest) {
+		if train == nil {
+			return
+		}
+		if head := train.Remove(pr); head != nil {
+			go p.enqueue(head)
+		}
+	}
 
 	// rebase queue contains pull requests which are:
 	//  - open
 	//  - green
 	//  - marked with mergeLabel
 	//  - mergeable
-	rebaseQueue := verifyPullRequest(client.Issues, client.Repositories, mergeLabel, merge(
-		prQueue,
+
+	var approval approvalChecker
+	if r.reviews.enabled() {
+		approval = (&reviewer{
+			policy:   r.reviews,
+			reviews:  client.PullRequests,
+			files:    client.PullRequests,
+			contents: client.Repositories,
+			teams:    client.Organizations,
+			ttl:      5 * time.Minute,
+		}).check
+	}
+	if r.reviews.DismissStaleApprovals {
+		r.Pushed = func(pr *github.PullRequest) {
+			dismissApprovals(client.PullRequests, pr, fmt.Sprintf("Dismissed after rebasing onto %s", r.Mainline))
+		}
+	}
+
+	events := merge(
+		p.prQueue,
 		processors.MainlineStatusEvent(r.Repository, client.PullRequests, mainlineStatusEventQueue),
-		processors.IssuesEvent(client.PullRequests, issueQueue),
+		processors.IssuesEvent(client.PullRequests, p.issueQueue),
 		processors.StatusEvent(client.PullRequests, statusPRQueue),
-		processors.PushEvent(r.Repository, client.PullRequests, pushEventQueue),
-		processors.PullRequestReviewEvent(client, reviewQueue),
-	))
+		processors.CheckRunEvent(r.Repository, client.PullRequests, checkRunQueue),
+		processors.CheckSuiteEvent(r.Repository, client.PullRequests, checkSuiteQueue),
+		processors.PushEvent(r.Repository, client.PullRequests, p.pushEventQueue),
+		processors.PullRequestReviewEvent(client, p.reviewQueue),
+	)
+	if len(rebaseLabels) > 0 {
+		events = autorebase(client.Issues, rebaseLabels, mergeLabel, p.rebase, events)
+	}
+
+	verified := verifyPullRequest(client.Issues, client.Repositories, checks.NewService(client), r.mergeLabel, required, approval, events, func(rej rejection) {
+		switch rej.Verdict {
+		case rejectPending:
+			rep.Progress(rej.PR, fmt.Sprintf("waiting for CI: %s", rej.Reason))
+			board.Set(rej.PR, fmt.Sprintf("waiting for CI: %s", rej.Reason))
+			return
+		case rejectBlocked:
+			rep.Block(rej.PR, rej.Reason)
+			board.Set(rej.PR, fmt.Sprintf("blocked: %s", rej.Reason))
+		case rejectFailed:
+			r.Forget(rej.PR)
+			rep.Block(rej.PR, rej.Reason)
+			unlabel.Unlabel(rej.PR, rej.Reason)
+			board.Set(rej.PR, fmt.Sprintf("blocked: %s", rej.Reason))
+		case rejectIgnored:
+			r.Forget(rej.PR)
+			board.Set(rej.PR, fmt.Sprintf("ignored: %s", rej.Reason))
+			if rej.PR.GetState() != "open" {
+				rep.Forget(rej.PR)
+				forks.Forget(rej.PR)
+			}
+		}
+		advance(rej.PR)
+		if batch != nil {
+			batch.Remove(rej.PR)
+		}
+	})
+	verified = tap(verified, func(pr *github.PullRequest) {
+		board.Set(pr, "queued")
+	})
+	if train != nil {
+		verified = train.Filter(verified)
+	}
+	rebaseQueue := recordStage(r.Repository, journal.StageVerified, unlabel.Unblock(verified))
 
 	handleRebase := func(input <-chan processors.RebaseResult) <-chan *github.PullRequest {
 		ret := make(chan *github.PullRequest)
@@ -71,23 +442,99 @@ func prHandler(r repository, client *github.Client) http.HandlerFunc {
 
 				// retry PRs where mainline changed during the rebase
 				if res.Error == processors.ErrMainlineChanged {
-					prQueue <- res.PR
+					p.enqueue(res.PR)
+					continue
+				}
+
+				// retry PRs which changed during the rebase with their new head
+				if isStale(res.Error) {
+					log.Printf("%s/%s: pr %d changed while rebasing, retrying: %v\n", r.Owner, r.Name, res.PR.GetNumber(), res.Error)
+					pr, _, err := client.PullRequests.Get(context.Background(), r.Owner, r.Name, res.PR.GetNumber())
+					if err != nil {
+						// the worker fetches the new head anyway, so the outdated PR is retried
+						log.Printf("%s/%s: pr %d failed to lookup new head, retrying: %v\n", r.Owner, r.Name, res.PR.GetNumber(), err)
+						pr = res.PR
+					}
+					p.enqueue(pr)
+					continue
+				}
+
+				// park PRs while mainline can't be fetched and retry them later
+				if delay, ok := processors.Retryable(res.Error); ok {
+					log.Printf("%s/%s: pr %d parked for %s: %v\n", r.Owner, r.Name, res.PR.GetNumber(), delay, res.Error)
+					board.Set(res.PR, fmt.Sprintf("waiting for %s to be fetched again", r.Mainline))
+					pr := res.PR
+					time.AfterFunc(delay, func() { p.enqueue(pr) })
+					continue
+				}
+
+				// retry PRs which failed for other reasons, e.g. an expired token, later.
+				// Only conflicts fail definitely
+				if !isConflict(res.Error) {
+					log.Printf("%s/%s: pr %d failed to rebase, retrying in %s: %v\n", r.Owner, r.Name, res.PR.GetNumber(), rebaseRetryDelay, res.Error)
+					rep.Block(res.PR, rebaseFailure(r.Mainline, res.Error))
+					board.Set(res.PR, fmt.Sprintf("blocked: %s, retrying in %s", rebaseFailure(r.Mainline, res.Error), rebaseRetryDelay))
+					pr := res.PR
+					time.AfterFunc(rebaseRetryDelay, func() { p.enqueue(pr) })
 					continue
 				}
 
 				log.Printf("filtering PR #%d on %s because of %v", res.PR.GetNumber(), r.Name, res.Error)
+				r.Forget(res.PR)
+				rep.Block(res.PR, rebaseFailure(r.Mainline, res.Error))
+				unlabel.Unlabel(res.PR, rebaseFailure(r.Mainline, res.Error))
+				board.Set(res.PR, fmt.Sprintf("blocked: %s", rebaseFailure(r.Mainline, res.Error)))
+				advance(res.PR)
 			}
+			close(ret)
 		}()
 		return ret
 	}
 
-	doneQueue := processors.Merge(client,
-		handleRebase(processors.Rebase(r.Repository, rebaseQueue)),
-	)
+	var doneQueue <-chan *github.PullRequest
+	switch {
+	case batch != nil:
+		doneQueue = batch.Run(rebaseQueue, stagingQueue)
+	case r.mergeMode == mergeModeRebase:
+		// nothing is merged in rebase mode, so the merge step is skipped entirely
+		done := make(chan *github.PullRequest)
+		go func() {
+			for range rebaseQueue {
+			}
+			close(done)
+		}()
+		doneQueue = done
+	default:
+		pushable, readonly := forks.Split(rebaseQueue)
+		doneQueue = processors.Merge(r.Repository, client,
+			recordStage(r.Repository, journal.StageMerging, merge(
+				handleRebase(processors.Rebase(r.Repository, pushable)),
+				readonly,
+			)),
+			func(pr *github.PullRequest, err error) {
+				r.Forget(pr)
+				rep.Block(pr, fmt.Sprintf("merging failed: %v", err))
+				board.Set(pr, fmt.Sprintf("blocked: merging failed: %v", err))
+				advance(pr)
+			},
+		)
+	}
 
 	go func() {
 		for pr := range doneQueue {
 			fmt.Printf("merged PR #%d\n", *pr.Number)
+			r.Forget(pr)
+			rep.Merged(pr)
+			forks.Forget(pr)
+			board.Remove(pr)
+			if batch != nil && r.DeleteBranch {
+				// github marks fast-forwarded PRs as merged but keeps their branches
+				processors.DeleteBranch(r.Repository, client, pr)
+			}
+			if train != nil {
+				// the next head is picked up by re-evaluating all open PRs below
+				train.Remove(pr)
+			}
 
 			// re-evaluate all open PRs to kick off new rebase if necessary
 			prs, _, err := client.PullRequests.List(
@@ -101,11 +548,82 @@ func prHandler(r repository, client *github.Client) http.HandlerFunc {
 				continue
 			}
 			for _, pr := range prs {
-				prQueue <- pr
+				p.enqueue(pr)
 			}
 		}
+		close(p.done)
 	}()
 
+	// rebasing on request does not journal, so it's not resumed into a merge
+	rebaseOnly := r.Repository
+	rebaseOnly.Journal = nil
+	rebaseOnlyQueue, readonly := forks.Split(p.rebaseQueue)
+	go func() {
+		for pr := range readonly {
+			board.Set(pr, "not rebased: edits by maintainers are not allowed")
+		}
+	}()
+	go func() {
+		for res := range processors.Rebase(rebaseOnly, rebaseOnlyQueue) {
+			switch {
+			case res.Error == processors.ErrMainlineChanged:
+				go p.rebase(res.PR)
+			case isStale(res.Error):
+				log.Printf("%s/%s: pr %d changed while rebasing, retrying: %v\n", r.Owner, r.Name, res.PR.GetNumber(), res.Error)
+				go p.rebase(res.PR)
+			case isRetryable(res.Error):
+				delay, _ := processors.Retryable(res.Error)
+				log.Printf("%s/%s: pr %d parked for %s: %v\n", r.Owner, r.Name, res.PR.GetNumber(), delay, res.Error)
+				board.Set(res.PR, fmt.Sprintf("waiting for %s to be fetched again", r.Mainline))
+				pr := res.PR
+				time.AfterFunc(delay, func() { p.rebase(pr) })
+			case res.Error != nil:
+				log.Printf("%s/%s: pr %d failed to rebase on request: %v\n", r.Owner, r.Name, res.PR.GetNumber(), res.Error)
+				rep.Block(res.PR, rebaseFailure(r.Mainline, res.Error))
+				board.Set(res.PR, fmt.Sprintf("blocked: %s", rebaseFailure(r.Mainline, res.Error)))
+			default:
+				log.Printf("%s/%s: pr %d is up to date with %s\n", r.Owner, r.Name, res.PR.GetNumber(), r.Mainline)
+			}
+		}
+	}()
+
+	cmds := &commander{
+		owner:       r.Owner,
+		name:        r.Name,
+		mergeLabel:  r.mergeLabel,
+		pulls:       client.PullRequests,
+		permissions: client.Repositories,
+		reactions:   client.Reactions,
+		labels:      client.Issues,
+		enqueue:     p.enqueue,
+		rebase:      p.rebase,
+		prioritize: func(pr *github.PullRequest) bool {
+			switch {
+			case train != nil:
+				train.Prioritize(pr)
+			case batch != nil:
+				batch.Prioritize(pr)
+			default:
+				return false
+			}
+			return true
+		},
+		status: func(pr *github.PullRequest) string {
+			view := board.Get(pr)
+			pos := -1
+			if train != nil {
+				pos = train.Position(pr)
+			} else if batch != nil {
+				pos = batch.Position(pr)
+			}
+			if pos >= 0 {
+				view = fmt.Sprintf("%s, position %d in the queue", view, pos+1)
+			}
+			return view
+		},
+	}
+	go cmds.Run(p.commentQueue)
+
 	// evaluate all open PRs on startup to kick off new rebase if necessary
 	prs, _, err := client.PullRequests.List(
 		context.Background(),
@@ -114,49 +632,132 @@ func prHandler(r repository, client *github.Client) http.HandlerFunc {
 		&github.PullRequestListOptions{
 			State: "open",
 		})
+	isResumed := map[int]bool{}
+	for _, pr := range resumed {
+		isResumed[pr.GetNumber()] = true
+		p.enqueue(pr)
+	}
 	if err != nil {
 		log.Printf("failed to populate open PRs on startup: %v", err)
 	} else {
 		for _, pr := range prs {
-			prQueue <- pr
+			// resumed PRs are enqueued already
+			if !isResumed[pr.GetNumber()] {
+				p.enqueue(pr)
+			}
 		}
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		eventType := req.Header.Get("X-GitHub-Event")
+	return p
+}
 
-		if eventType == "pull_request" {
-			evt := new(github.PullRequestEvent)
-			json.NewDecoder(req.Body).Decode(evt)
+func (p *pipeline) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r := p.r
+	payload, err := validatePayload(req, r.secret)
+	if err != nil {
+		log.Printf("%s/%s: rejecting event from %s: %v\n", r.Owner, r.Name, req.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
 
-			prQueue <- evt.PullRequest
+	shuttingDown := func() {
+		http.Error(w, "repository is shutting down", http.StatusServiceUnavailable)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		shuttingDown()
+		return
+	}
 
-			if evt.PullRequest.GetState() == "closed" {
-				cache := repos.Find(evt.PullRequest.Base.User.GetLogin(), evt.PullRequest.Base.Repo.GetName()).Repository.Cache
-				cache.Cleanup(repo.StringGitWorktree(evt.PullRequest.Head.GetRef()))
-			}
-		} else if eventType == "pull_request_review" {
-			evt := new(github.PullRequestReviewEvent)
-			json.NewDecoder(req.Body).Decode(evt)
+	eventType := req.Header.Get("X-GitHub-Event")
 
-			reviewQueue <- evt
-		} else if eventType == "issues" {
-			evt := new(github.IssuesEvent)
-			json.NewDecoder(req.Body).Decode(evt)
+	if eventType == "pull_request" {
+		evt := new(github.PullRequestEvent)
+		json.Unmarshal(payload, evt)
 
-			issueQueue <- evt
-		} else if eventType == "status" {
-			evt := new(github.StatusEvent)
-			json.NewDecoder(req.Body).Decode(evt)
+		select {
+		case p.prQueue <- evt.PullRequest:
+		case <-p.quit:
+			shuttingDown()
+			return
+		}
 
-			statusEventQueue <- evt
-		} else if eventType == "push" {
-			evt := new(github.PushEvent)
-			json.NewDecoder(req.Body).Decode(evt)
+		if evt.PullRequest.GetState() == "closed" {
+			r.Forget(evt.PullRequest)
+			r.Cache.Cleanup(r.Head(evt.PullRequest))
+		}
+	} else if eventType == "pull_request_review" {
+		evt := new(github.PullRequestReviewEvent)
+		json.Unmarshal(payload, evt)
 
-			pushEventQueue <- evt
-		} else {
-			log.Printf("%s/%s: Event %s not supported yet.\n", r.Owner, r.Name, eventType)
+		select {
+		case p.reviewQueue <- evt:
+		case <-p.quit:
+			shuttingDown()
+			return
 		}
-	})
+	} else if eventType == "issues" {
+		evt := new(github.IssuesEvent)
+		json.Unmarshal(payload, evt)
+
+		select {
+		case p.issueQueue <- evt:
+		case <-p.quit:
+			shuttingDown()
+			return
+		}
+	} else if eventType == "status" {
+		evt := new(github.StatusEvent)
+		json.Unmarshal(payload, evt)
+
+		select {
+		case p.statusEventQueue <- evt:
+		case <-p.quit:
+			shuttingDown()
+			return
+		}
+	} else if eventType == "check_run" {
+		evt := new(checks.CheckRunEvent)
+		json.Unmarshal(payload, evt)
+
+		select {
+		case p.checkRunQueue <- evt:
+		case <-p.quit:
+			shuttingDown()
+			return
+		}
+	} else if eventType == "check_suite" {
+		evt := new(checks.CheckSuiteEvent)
+		json.Unmarshal(payload, evt)
+
+		select {
+		case p.checkSuiteQueue <- evt:
+		case <-p.quit:
+			shuttingDown()
+			return
+		}
+	} else if eventType == "issue_comment" {
+		evt := new(github.IssueCommentEvent)
+		json.Unmarshal(payload, evt)
+
+		select {
+		case p.commentQueue <- evt:
+		case <-p.quit:
+			shuttingDown()
+			return
+		}
+	} else if eventType == "push" {
+		evt := new(github.PushEvent)
+		json.Unmarshal(payload, evt)
+
+		select {
+		case p.pushEventQueue <- evt:
+		case <-p.quit:
+			shuttingDown()
+			return
+		}
+	} else {
+		log.Printf("%s/%s: Event %s not supported yet.\n", r.Owner, r.Name, eventType)
+	}
 }
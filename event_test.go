@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
+	"github.com/nicolai86/github-rebase-bot/journal"
+	"github.com/nicolai86/github-rebase-bot/processors"
 )
 
+type fakePullRequestGetter func(int) (*github.PullRequest, *github.Response, error)
+
+func (f fakePullRequestGetter) Get(ctx context.Context, _ string, _ string, number int) (*github.PullRequest, *github.Response, error) {
+	return f(number)
+}
+
 func TestStatusEventBroadcaster(t *testing.T) {
 	inp := make(chan *github.StatusEvent)
 	q1 := make(chan *github.StatusEvent)
@@ -28,3 +39,123 @@ func TestStatusEventBroadcaster(t *testing.T) {
 	inp <- &github.StatusEvent{}
 	w.Wait()
 }
+
+func TestResume(t *testing.T) {
+	client := fakePullRequestGetter(func(number int) (*github.PullRequest, *github.Response, error) {
+		state := "open"
+		if number == 5 {
+			state = "closed"
+		}
+		return &github.PullRequest{
+			Number: intVal(number),
+			State:  stringVal(state),
+		}, nil, nil
+	})
+
+	var r repository
+	r.Owner = "test"
+	r.Name = "test"
+	rebase, merge, verify := resume(r, client, []journal.Entry{
+		{Number: 1, Stage: journal.StageVerified},
+		{Number: 2, Stage: journal.StageRebasing},
+		{Number: 3, Stage: journal.StagePushed},
+		{Number: 4, Stage: journal.StageMerging},
+		{Number: 5, Stage: journal.StageMerging},
+	})
+
+	if len(rebase) != 2 || rebase[0].GetNumber() != 1 || rebase[1].GetNumber() != 2 {
+		t.Fatalf("Expected #1 and #2 to resume rebasing, but got %v", rebase)
+	}
+	if len(verify) != 1 || verify[0].GetNumber() != 3 {
+		t.Fatalf("Expected #3 to resume verification, but got %v", verify)
+	}
+	if len(merge) != 1 || merge[0].GetNumber() != 4 {
+		t.Fatalf("Expected #4 to resume merging, but got %v", merge)
+	}
+}
+
+func TestPipeline_StopWithFullQueue(t *testing.T) {
+	p := &pipeline{
+		done:             make(chan struct{}),
+		quit:             make(chan struct{}),
+		issueQueue:       make(chan *github.IssuesEvent),
+		prQueue:          make(chan *github.PullRequest),
+		reviewQueue:      make(chan *github.PullRequestReviewEvent),
+		pushEventQueue:   make(chan *github.PushEvent),
+		statusEventQueue: make(chan *github.StatusEvent),
+		checkRunQueue:    make(chan *checks.CheckRunEvent),
+		checkSuiteQueue:  make(chan *checks.CheckSuiteEvent),
+		commentQueue:     make(chan *github.IssueCommentEvent),
+		rebaseQueue:      make(chan *github.PullRequest),
+	}
+	close(p.done)
+
+	// nothing reads prQueue, so the send blocks while holding the read lock
+	sent := make(chan bool)
+	go func() { sent <- p.enqueue(&github.PullRequest{}) }()
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Stop to return, but it blocked")
+	}
+	if <-sent {
+		t.Fatal("Expected enqueue to give up once stopped, but it succeeded")
+	}
+	if p.enqueue(&github.PullRequest{}) {
+		t.Fatal("Expected enqueue to be rejected after stopping, but it succeeded")
+	}
+}
+
+func TestBoardTrain(t *testing.T) {
+	train := processors.NewTrain()
+	train.Add(&github.PullRequest{Number: intVal(1)})
+	boardTrain(train, []*github.PullRequest{{Number: intVal(2)}}, []*github.PullRequest{{Number: intVal(3)}})
+
+	for i, number := range []int{1, 2, 3} {
+		if pos := train.Position(&github.PullRequest{Number: intVal(number)}); pos != i {
+			t.Fatalf("Expected #%d at position %d, but got %d", number, i, pos)
+		}
+	}
+}
+
+func TestStagingEvents(t *testing.T) {
+	statuses := make(chan *github.StatusEvent, 2)
+	runs := make(chan *checks.CheckRunEvent, 2)
+	suites := make(chan *checks.CheckSuiteEvent, 1)
+	statuses <- &github.StatusEvent{State: stringVal("pending"), SHA: stringVal("a")}
+	statuses <- &github.StatusEvent{State: stringVal("success"), SHA: stringVal("b")}
+	runs <- &checks.CheckRunEvent{Action: stringVal("created"), CheckRun: &checks.CheckRun{HeadSHA: stringVal("c")}}
+	runs <- &checks.CheckRunEvent{Action: stringVal("completed"), CheckRun: &checks.CheckRun{HeadSHA: stringVal("d")}}
+	suites <- &checks.CheckSuiteEvent{Action: stringVal("completed"), CheckSuite: &checks.CheckSuite{HeadSHA: stringVal("e")}}
+	close(statuses)
+	close(runs)
+	close(suites)
+
+	shas, passedRuns, passedSuites := stagingEvents(statuses, runs, suites)
+	n := 0
+	for range passedRuns {
+		n++
+	}
+	for range passedSuites {
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("Expected all 3 check events to pass through, but got %d", n)
+	}
+
+	seen := map[string]bool{}
+	for sha := range shas {
+		seen[sha] = true
+	}
+	if len(seen) != 3 || !seen["b"] || !seen["d"] || !seen["e"] {
+		t.Fatalf("Expected shas of completed events, but got %v", seen)
+	}
+}
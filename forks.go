@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/processors"
+)
+
+// CommentCreator comments on issues
+type CommentCreator interface {
+	CreateComment(context.Context, string, string, int, *github.IssueComment) (*github.IssueComment, *github.Response, error)
+}
+
+// forkGuard keeps pull requests from forks which don't allow edits by maintainers
+// away from rebasing, since the bot can't push to them. Their authors are asked
+// once to allow edits or to update the branch themselves
+type forkGuard struct {
+	r        processors.Repository
+	comments CommentCreator
+
+	mu sync.Mutex
+	// notified contains the pull requests whose authors were asked already
+	notified map[int]bool
+}
+
+func newForkGuard(r processors.Repository, comments CommentCreator) *forkGuard {
+	return &forkGuard{r: r, comments: comments, notified: map[int]bool{}}
+}
+
+// Pushable reports if the bot can push to the head branch of a pull request
+func (g *forkGuard) Pushable(pr *github.PullRequest) bool {
+	if g.r.IsDeletedFork(pr) {
+		return false
+	}
+	return !g.r.IsFork(pr) || pr.GetMaintainerCanModify()
+}
+
+// Split passes pull requests the bot can push to on pushable. All other pull
+// requests are passed on readonly, to be merged without rebasing them
+func (g *forkGuard) Split(input <-chan *github.PullRequest) (<-chan *github.PullRequest, <-chan *github.PullRequest) {
+	pushable, readonly := make(chan *github.PullRequest), make(chan *github.PullRequest)
+	go func() {
+		for pr := range input {
+			if g.Pushable(pr) {
+				pushable <- pr
+				continue
+			}
+			log.Printf("%s/%s: pr %d is from a fork which can't be pushed to, merging without rebasing\n", g.r.Owner, g.r.Name, pr.GetNumber())
+			g.Notify(pr)
+			readonly <- pr
+		}
+		close(pushable)
+		close(readonly)
+	}()
+	return pushable, readonly
+}
+
+// Notify asks the author of a pull request to allow edits by maintainers, once
+func (g *forkGuard) Notify(pr *github.PullRequest) {
+	g.mu.Lock()
+	notified := g.notified[pr.GetNumber()]
+	g.notified[pr.GetNumber()] = true
+	g.mu.Unlock()
+	if notified {
+		return
+	}
+
+	body := fmt.Sprintf("@%s rebase-bot can't push to `%s` because edits by maintainers are not allowed, so it's not rebased onto `%s`.\n\nAllow edits by maintainers to have it rebased automatically.", pr.User.GetLogin(), pr.Head.GetLabel(), g.r.Mainline)
+	if g.r.IsDeletedFork(pr) {
+		body = fmt.Sprintf("@%s rebase-bot can't push to `%s` because its repository was deleted, so it's not rebased onto `%s`.", pr.User.GetLogin(), pr.Head.GetLabel(), g.r.Mainline)
+	}
+	if _, _, err := g.comments.CreateComment(context.Background(), g.r.Owner, g.r.Name, pr.GetNumber(), &github.IssueComment{Body: &body}); err != nil {
+		log.Printf("%s/%s: pr %d failed to notify author: %v\n", g.r.Owner, g.r.Name, pr.GetNumber(), err)
+	}
+}
+
+// Forget drops a closed or merged pull request, so its author is asked again if it's reopened
+func (g *forkGuard) Forget(pr *github.PullRequest) {
+	g.mu.Lock()
+	delete(g.notified, pr.GetNumber())
+	g.mu.Unlock()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/processors"
+)
+
+func forkPullRequest(number int, fullName string, canModify bool) *github.PullRequest {
+	return &github.PullRequest{
+		Number:              intVal(number),
+		User:                &github.User{Login: stringVal("author")},
+		MaintainerCanModify: &canModify,
+		Head: &github.PullRequestBranch{
+			Ref:  stringVal("feature"),
+			Repo: &github.Repository{FullName: stringVal(fullName)},
+		},
+	}
+}
+
+func TestForkGuard(t *testing.T) {
+	r := processors.Repository{Owner: "test", Name: "test", Mainline: "master"}
+
+	t.Run("splits pull requests by whether they can be pushed to", func(t *testing.T) {
+		client := &fakeLabelClient{}
+		g := newForkGuard(r, client)
+
+		input := make(chan *github.PullRequest, 3)
+		input <- forkPullRequest(1, "test/test", false)
+		input <- forkPullRequest(2, "author/test", true)
+		input <- forkPullRequest(3, "author/test", false)
+		close(input)
+
+		pushable, readonly := g.Split(input)
+		var pushed, merged []int
+		for pushable != nil || readonly != nil {
+			select {
+			case pr, ok := <-pushable:
+				if !ok {
+					pushable = nil
+					continue
+				}
+				pushed = append(pushed, pr.GetNumber())
+			case pr, ok := <-readonly:
+				if !ok {
+					readonly = nil
+					continue
+				}
+				merged = append(merged, pr.GetNumber())
+			}
+		}
+
+		if len(pushed) != 2 || pushed[0] != 1 || pushed[1] != 2 {
+			t.Fatalf("Expected pull requests 1 and 2 to be pushable, but got %v", pushed)
+		}
+		if len(merged) != 1 || merged[0] != 3 {
+			t.Fatalf("Expected pull request 3 to be readonly, but got %v", merged)
+		}
+	})
+
+	t.Run("notifies authors once", func(t *testing.T) {
+		client := &fakeLabelClient{}
+		g := newForkGuard(r, client)
+
+		g.Notify(forkPullRequest(3, "author/test", false))
+		g.Notify(forkPullRequest(3, "author/test", false))
+
+		if len(client.comments) != 1 || !strings.HasPrefix(client.comments[0], "@author") {
+			t.Fatalf("Expected the author to be notified once, but got %v", client.comments)
+		}
+	})
+	t.Run("forgets closed pull requests", func(t *testing.T) {
+		client := &fakeLabelClient{}
+		g := newForkGuard(r, client)
+
+		g.Notify(forkPullRequest(3, "author/test", false))
+		g.Forget(forkPullRequest(3, "author/test", false))
+
+		if len(g.notified) != 0 {
+			t.Fatalf("Expected pull request 3 to be forgotten, but got %v", g.notified)
+		}
+	})
+}
+
+func TestRepository_Head(t *testing.T) {
+	r := processors.Repository{Owner: "test", Name: "test", ForkURL: func(fullName string) string {
+		return "https://github.com/" + fullName + ".git"
+	}}
+
+	head := r.Head(forkPullRequest(1, "test/test", false))
+	if head.Fork() || head.Key() != "test/test#1" {
+		t.Fatalf("Expected a branch of the base repository, but got %v", head)
+	}
+
+	head = r.Head(forkPullRequest(2, "author/test", true))
+	if head.URL != "https://github.com/author/test.git" || head.Key() != "author/test#2" {
+		t.Fatalf("Expected a branch of the fork, but got %v", head)
+	}
+
+	// github omits the head repository of deleted forks
+	deleted := forkPullRequest(3, "", true)
+	deleted.Head.Repo = nil
+	if !r.IsFork(deleted) {
+		t.Fatal("Expected deleted forks to be forks, but they weren't")
+	}
+	head = r.Head(deleted)
+	if !head.Fork() || head.URL != "" {
+		t.Fatalf("Expected a branch of a deleted fork, but got %v", head)
+	}
+	if g := newForkGuard(r, &fakeLabelClient{}); g.Pushable(deleted) {
+		t.Fatal("Expected deleted forks not to be pushable, but they were")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// githubHost describes the github instance the bot works with, either github.com
+// or a github enterprise server
+type githubHost struct {
+	api    *url.URL
+	upload *url.URL
+	// web is the url repositories are cloned from, e.g. https://github.com
+	web string
+}
+
+// defaultGitHubHost is github.com
+var defaultGitHubHost = githubHost{
+	api:    mustParseURL("https://api.github.com/"),
+	upload: mustParseURL("https://uploads.github.com/"),
+	web:    "https://github.com",
+}
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// parseGitHubHost reads the urls of a github enterprise server. apiURL is either
+// the url of the server or of its api, e.g. https://github.example.com/api/v3/.
+// uploadURL defaults to the upload api of the same server. Without an apiURL github.com is used
+func parseGitHubHost(apiURL, uploadURL string) (githubHost, error) {
+	if apiURL == "" {
+		if uploadURL != "" {
+			return githubHost{}, fmt.Errorf("upload url %q requires a github url", uploadURL)
+		}
+		return defaultGitHubHost, nil
+	}
+
+	api, err := url.Parse(apiURL)
+	if err != nil {
+		return githubHost{}, err
+	}
+	if api.Scheme == "" || api.Host == "" {
+		return githubHost{}, fmt.Errorf("github url %q must be absolute", apiURL)
+	}
+	h := githubHost{web: fmt.Sprintf("%s://%s", api.Scheme, api.Host)}
+
+	api.Path = strings.TrimSuffix(api.Path, "/")
+	if !strings.HasSuffix(api.Path, "/api/v3") {
+		api.Path += "/api/v3"
+	}
+	api.Path += "/"
+	h.api = api
+
+	if uploadURL == "" {
+		uploadURL = fmt.Sprintf("%s/api/uploads/", h.web)
+	}
+	upload, err := url.Parse(uploadURL)
+	if err != nil {
+		return githubHost{}, err
+	}
+	if !strings.HasSuffix(upload.Path, "/") {
+		upload.Path += "/"
+	}
+	h.upload = upload
+	return h, nil
+}
+
+// Client returns an api client of the host
+func (h githubHost) Client(httpClient *http.Client) *github.Client {
+	c := github.NewClient(httpClient)
+	c.BaseURL, c.UploadURL = h.api, h.upload
+	return c
+}
+
+// CloneURL returns the url git uses to fetch and push a repository. Credentials
+// are supplied by the repo package, so they never end up in urls
+func (h githubHost) CloneURL(fullName string) string {
+	return fmt.Sprintf("%s/%s.git", h.web, fullName)
+}
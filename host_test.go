@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseGitHubHost(t *testing.T) {
+	for _, tc := range []struct {
+		api, upload                        string
+		expectedAPI, expectedUpload, clone string
+	}{
+		{"", "", "https://api.github.com/", "https://uploads.github.com/", "https://github.com/test/test.git"},
+		{"https://github.example.com", "", "https://github.example.com/api/v3/", "https://github.example.com/api/uploads/", "https://github.example.com/test/test.git"},
+		{"https://github.example.com/api/v3", "", "https://github.example.com/api/v3/", "https://github.example.com/api/uploads/", "https://github.example.com/test/test.git"},
+		{"https://github.example.com/api/v3/", "https://uploads.example.com", "https://github.example.com/api/v3/", "https://uploads.example.com/", "https://github.example.com/test/test.git"},
+	} {
+		h, err := parseGitHubHost(tc.api, tc.upload)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.api, err)
+		}
+		c := h.Client(nil)
+		if c.BaseURL.String() != tc.expectedAPI || c.UploadURL.String() != tc.expectedUpload {
+			t.Fatalf("%q: Expected %q and %q, but got %q and %q", tc.api, tc.expectedAPI, tc.expectedUpload, c.BaseURL, c.UploadURL)
+		}
+		if url := h.CloneURL("test/test"); url != tc.clone {
+			t.Fatalf("%q: Expected to clone %q, but got %q", tc.api, tc.clone, url)
+		}
+	}
+
+	for _, tc := range [][2]string{{"github.example.com", ""}, {"", "https://uploads.example.com"}} {
+		if _, err := parseGitHubHost(tc[0], tc[1]); err == nil {
+			t.Fatalf("Expected %q, %q to be rejected", tc[0], tc[1])
+		}
+	}
+}
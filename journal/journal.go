@@ -0,0 +1,170 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// Stage describes how far a pull request progressed through the pipeline
+type Stage string
+
+const (
+	// StageVerified pull requests are open, labeled, mergeable and green
+	StageVerified Stage = "verified"
+	// StageRebasing pull requests are handed to a worker
+	StageRebasing Stage = "rebasing"
+	// StagePushed pull requests were rebased and pushed and wait for CI
+	StagePushed Stage = "pushed"
+	// StageMerging pull requests are up to date and about to be merged
+	StageMerging Stage = "merging"
+)
+
+// Entry is the last recorded stage of a single pull request
+type Entry struct {
+	Owner     string    `json:"owner"`
+	Name      string    `json:"name"`
+	Number    int       `json:"number"`
+	Stage     Stage     `json:"stage"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func key(owner, name string, number int) string {
+	return strings.ToLower(fmt.Sprintf("%s/%s#%d", owner, name, number))
+}
+
+// Journal persists the stage of every pull request in the pipeline to a
+// JSON file so processing can resume after a restart
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]Entry
+}
+
+// Open loads the journal stored at path. A missing file results in an empty journal
+func Open(path string) (*Journal, error) {
+	j := &Journal{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, e := range entries {
+		j.entries[key(e.Owner, e.Name, e.Number)] = e
+	}
+	return j, nil
+}
+
+// Record stores the stage of a pull request
+func (j *Journal) Record(pr *github.PullRequest, stage Stage) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	owner, name := pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName()
+	j.entries[key(owner, name, pr.GetNumber())] = Entry{
+		Owner:     owner,
+		Name:      name,
+		Number:    pr.GetNumber(),
+		Stage:     stage,
+		UpdatedAt: time.Now(),
+	}
+	return j.save()
+}
+
+// Remove forgets a pull request, e.g. because it was merged or closed
+func (j *Journal) Remove(pr *github.PullRequest) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	k := key(pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber())
+	if _, ok := j.entries[k]; !ok {
+		return nil
+	}
+	delete(j.entries, k)
+	return j.save()
+}
+
+// Purge forgets all pull requests of a repository
+func (j *Journal) Purge(owner, name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for k, e := range j.entries {
+		if strings.EqualFold(e.Owner, owner) && strings.EqualFold(e.Name, name) {
+			delete(j.entries, k)
+		}
+	}
+	return j.save()
+}
+
+// Entries returns all recorded pull requests of a repository ordered by number
+func (j *Journal) Entries(owner, name string) []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := []Entry{}
+	for _, e := range j.entries {
+		if strings.EqualFold(e.Owner, owner) && strings.EqualFold(e.Name, name) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Number < entries[b].Number
+	})
+	return entries
+}
+
+// save atomically replaces the journal file. The caller must hold j.mu
+func (j *Journal) save() error {
+	entries := make([]Entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Owner+entries[a].Name != entries[b].Owner+entries[b].Name {
+			return entries[a].Owner+entries[a].Name < entries[b].Owner+entries[b].Name
+		}
+		return entries[a].Number < entries[b].Number
+	})
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(j.path), filepath.Base(j.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
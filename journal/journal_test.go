@@ -0,0 +1,101 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func pullRequest(owner, name string, number int) *github.PullRequest {
+	return &github.PullRequest{
+		Number: &number,
+		Base: &github.PullRequestBranch{
+			Repo: &github.Repository{
+				Name: &name,
+				Owner: &github.User{
+					Login: &owner,
+				},
+			},
+		},
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.json")
+
+	t.Run("starts empty without file", func(t *testing.T) {
+		j, err := Open(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(j.Entries("test", "test")) != 0 {
+			t.Fatal("Expected empty journal")
+		}
+	})
+
+	t.Run("persists stages across instances", func(t *testing.T) {
+		j, err := Open(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := j.Record(pullRequest("test", "test", 2), StagePushed); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := j.Record(pullRequest("test", "test", 1), StageRebasing); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := j.Record(pullRequest("test", "test", 1), StageMerging); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := j.Record(pullRequest("test", "other", 3), StageVerified); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		reopened, err := Open(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		entries := reopened.Entries("test", "test")
+		if len(entries) != 2 {
+			t.Fatalf("Expected 2 entries, but got %d", len(entries))
+		}
+		if entries[0].Number != 1 || entries[0].Stage != StageMerging {
+			t.Fatalf("Unexpected entry %#v", entries[0])
+		}
+		if entries[1].Number != 2 || entries[1].Stage != StagePushed {
+			t.Fatalf("Unexpected entry %#v", entries[1])
+		}
+	})
+
+	t.Run("removes pull requests", func(t *testing.T) {
+		j, err := Open(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := j.Remove(pullRequest("test", "test", 1)); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := j.Purge("test", "other"); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		reopened, err := Open(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if entries := reopened.Entries("test", "test"); len(entries) != 1 || entries[0].Number != 2 {
+			t.Fatalf("Expected only #2 to remain, but got %#v", entries)
+		}
+		if entries := reopened.Entries("test", "other"); len(entries) != 0 {
+			t.Fatalf("Expected purged repository to be empty, but got %#v", entries)
+		}
+	})
+}
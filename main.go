@@ -7,13 +7,15 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
 	"github.com/nicolai86/github-rebase-bot/processors"
 	"github.com/nicolai86/github-rebase-bot/repo"
 	"golang.org/x/oauth2"
@@ -21,8 +23,13 @@ import (
 
 var (
 	token      string
+	appID      int
+	appKeyPath string
 	repos      repositories
 	mergeLabel string
+	hookSecret string
+	dataDir    string
+	configPath string
 )
 
 type repositories []repository
@@ -53,20 +60,54 @@ func (hps *repositories) Set(str string) error {
 
 type repository struct {
 	processors.Repository
-	hook *github.Hook
+	hook   *github.Hook
+	secret string
+
+	mergeLabel     string
+	rebaseLabel    string
+	mergeMode      string
+	updateStrategy repo.UpdateStrategy
+	batchSize      int
+	stagingBranch  string
+	requiredChecks []string
+	// protectedChecks reads required checks from the branch protection of mainline
+	protectedChecks bool
+	reviews         reviewPolicy
+	// reportStatus and reportComments explain the progress of pull requests on github
+	reportStatus   bool
+	reportComments bool
+	// unlabelBlocked swaps the merge label of pull requests which failed definitely for blockedLabel
+	unlabelBlocked bool
+	blockedLabel   string
+	registerHook   bool
+	hookEvents     []string
+
+	// config is the configuration the repository was created from
+	config repositoryConfig
+}
+
+// key identifies a repository independent of the casing of owner and name
+func (h *repository) key() string {
+	return strings.ToLower(fmt.Sprintf("%s/%s", h.Owner, h.Name))
 }
 
 func (h *repository) String() string {
-	return fmt.Sprintf("%s/%s#%s", h.Owner, h.Name, h.Mainline)
+	return fmt.Sprintf("%s/%s#%s:%s", h.Owner, h.Name, h.Mainline, h.MergeMethod)
 }
 
+// Set parses repositories in the form of owner/name#mainline:method
+// mainline and merge method are optional
 func (h *repository) Set(str string) error {
 	var parts = strings.Split(str, "/")
 	if len(parts) != 2 {
 		return fmt.Errorf("Invalid repository %q. Must be owner/name", str)
 	}
 	h.Owner = parts[0]
-	parts = strings.Split(parts[1], "#")
+	parts = strings.Split(parts[1], ":")
+	if len(parts) == 2 {
+		h.MergeMethod = parts[1]
+	}
+	parts = strings.Split(parts[0], "#")
 	h.Name = parts[0]
 	if len(parts) == 2 {
 		h.Mainline = parts[1]
@@ -74,6 +115,12 @@ func (h *repository) Set(str string) error {
 	if h.Mainline == "" {
 		h.Mainline = "master"
 	}
+	if h.MergeMethod == "" {
+		h.MergeMethod = processors.MergeMethodMerge
+	}
+	if !processors.ValidMergeMethod(h.MergeMethod) {
+		return fmt.Errorf("Invalid merge method %q for repository %q. Must be merge, squash or rebase", h.MergeMethod, str)
+	}
 	return nil
 }
 
@@ -83,51 +130,140 @@ func main() {
 	if token == "" {
 		token = os.Getenv("GITHUB_TOKEN")
 	}
+	flag.IntVar(&appID, "github-app-id", 0, "id of the github app to authenticate as, instead of -github-token")
+	flag.StringVar(&appKeyPath, "github-app-key", os.Getenv("GITHUB_APP_PRIVATE_KEY"), "path to the PEM encoded private key of the github app")
+	var githubURL, githubUploadURL string
+	flag.StringVar(&githubURL, "github-url", os.Getenv("GITHUB_URL"), "url of a github enterprise server, e.g. https://github.example.com. Defaults to github.com")
+	flag.StringVar(&githubUploadURL, "github-upload-url", os.Getenv("GITHUB_UPLOAD_URL"), "upload url of a github enterprise server. Derived from -github-url if empty")
+	var identity repo.Identity
+	var signingFormat, provenance string
+	flag.StringVar(&identity.Name, "git-user-name", "rebase bot", "name of the committer of rebased commits")
+	flag.StringVar(&identity.Email, "git-user-email", "rebase-bot@your.domain.com", "email of the committer of rebased commits")
+	flag.StringVar(&identity.SigningKey, "git-signing-key", os.Getenv("GIT_SIGNING_KEY"), "path to a private key rebased commits are signed with")
+	flag.StringVar(&signingFormat, "git-signing-format", string(repo.SigningGPG), "format of -git-signing-key, gpg or ssh")
+	flag.StringVar(&provenance, "git-provenance", "", "record the original of rebased commits: committer keeps the original committer, trailer appends Rebased-by and Original-SHA trailers")
+	flag.StringVar(&hookSecret, "webhook-secret", "", "secret used to sign webhook payloads of all repositories. Generated and persisted inside -data-dir if empty")
+	if hookSecret == "" {
+		hookSecret = os.Getenv("GITHUB_WEBHOOK_SECRET")
+	}
+	flag.StringVar(&dataDir, "data-dir", os.Getenv("DATA_DIR"), "persistent directory to keep the journal and generated secrets across restarts")
+	flag.StringVar(&configPath, "config", "", "path to a JSON configuration file describing all repositories")
 	var addr string
-	flag.Var(&repos, "repos", "github repos (owner/repo separated by commas)")
+	flag.Var(&repos, "repos", "github repos (owner/repo#mainline:method separated by commas)")
 	flag.StringVar(&publicDNS, "public-dns", "", "publicly accessible dns endpoint for webhook push")
 	flag.StringVar(&mergeLabel, "merge-label", "", "which label is checked to kick off the merge process")
 	flag.StringVar(&addr, "addr", "", "address to listen on")
 	flag.Parse()
 
-	if token == "" {
+	if appID == 0 {
+		if id, err := strconv.Atoi(os.Getenv("GITHUB_APP_ID")); err == nil {
+			appID = id
+		}
+	}
+	if token == "" && appID == 0 {
 		log.Fatal("Missing github token.")
 	}
-
-	if len(repos) == 0 {
-		log.Fatal("Missing repositories.")
+	if dataDir == "" {
+		log.Fatal("Missing data directory.")
 	}
+	if !repo.ValidSigningFormat(signingFormat) {
+		log.Fatalf("Invalid signing format %q. Must be gpg or ssh", signingFormat)
+	}
+	identity.SigningFormat = repo.SigningFormat(signingFormat)
+	if !repo.ValidProvenance(provenance) {
+		log.Fatalf("Invalid provenance %q. Must be committer or trailer", provenance)
+	}
+	identity.Provenance = repo.Provenance(provenance)
 
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(oauth2.NoContext, ts)
-
-	client := github.NewClient(tc)
-
-	user, _, err := client.Users.Get(context.Background(), "")
+	host, err := parseGitHubHost(githubURL, githubUploadURL)
 	if err != nil {
-		log.Fatalf("resolving github user failed: %v", err)
+		log.Fatalf("invalid github url: %v", err)
 	}
-	username := *user.Login
 
-	log.Printf("Bot started for user %s.\n", username)
-	log.Printf("Using %q as merge-label.\n", mergeLabel)
+	// github apps authenticate with installation tokens per repository
+	var creds credentials
+	var app *githubApp
+	if appID != 0 {
+		if appKeyPath == "" {
+			log.Fatal("Missing private key of the github app.")
+		}
+		app, err = loadGitHubApp(appID, appKeyPath, host)
+		if err != nil {
+			log.Fatalf("loading github app failed: %v", err)
+		}
+		creds = app
+		log.Printf("Bot started as github app %d.\n", appID)
+	} else {
+		ts := oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: token},
+		)
+		tc := oauth2.NewClient(oauth2.NoContext, ts)
+
+		client := host.Client(tc)
 
-	if err := exec.Command("git", "config", "--global", "user.name", "rebase bot").Run(); err != nil {
-		log.Fatal("git config --global user.name failed: %q", err)
+		user, _, err := client.Users.Get(context.Background(), "")
+		if err != nil {
+			log.Fatalf("resolving github user failed: %v", err)
+		}
+		log.Printf("Bot started for user %s.\n", user.GetLogin())
+		creds = tokenCredentials{client: client, token: token}
 	}
-	if err := exec.Command("git", "config", "--global", "user.email", "rebase-bot@your.domain.com").Run(); err != nil {
-		log.Fatal("git config --global user.email failed: %q", err)
+
+	// without a list of repositories github apps work on all repositories they are installed on
+	discover := app != nil && configPath == "" && len(repos) == 0
+	discoverRepositories := func() (*config, error) {
+		return discoverConfig(app, mergeLabel)
 	}
 
-	for i, r := range repos {
-		url := fmt.Sprintf("https://%s@github.com/%s/%s.git", token, r.Owner, r.Name)
-		c, err := repo.Prepare(url, r.Mainline)
+	var cfg *config
+	if discover {
+		if mergeLabel == "" {
+			log.Fatal("Missing merge label for discovered repositories.")
+		}
+		var err error
+		cfg, err = discoverRepositories()
 		if err != nil {
-			log.Fatalf("prepare failed: %v", err)
+			log.Fatalf("discovering repositories failed: %v", err)
+		}
+	} else if configPath != "" {
+		if len(repos) != 0 {
+			log.Fatal("-config and -repos are mutually exclusive.")
+		}
+		var err error
+		cfg, err = loadConfig(configPath)
+		if err != nil {
+			log.Fatalf("loading configuration failed: %v", err)
+		}
+	} else {
+		if len(repos) == 0 {
+			log.Fatal("Missing repositories.")
 		}
-		repos[i].Cache = c
+		cfg = flagConfig(repos, mergeLabel)
+	}
+	// apps may not be installed anywhere yet
+	if discover && len(cfg.Repositories) == 0 {
+		log.Printf("github app %d is not installed on any repository yet.\n", appID)
+	} else if err := cfg.validate(); err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	repos = cfg.repositories()
+	// identities may rely on features of newer git versions
+	if err := identity.Validate(); err != nil {
+		log.Fatalf("invalid git identity: %v", err)
+	}
+	for _, r := range repos {
+		if err := r.config.Commits.identity(identity).Validate(); err != nil {
+			log.Fatalf("invalid git identity of %s/%s: %v", r.Owner, r.Name, err)
+		}
+	}
+
+	secret, err := loadWebhookSecret(hookSecret, dataDir)
+	if err != nil {
+		log.Fatalf("loading webhook secret failed: %v", err)
+	}
+
+	for _, r := range repos {
+		log.Printf("%s/%s: Using %q as merge-label.\n", r.Owner, r.Name, r.mergeLabel)
 	}
 
 	// On ^C, or SIGTERM handle exit.
@@ -135,28 +271,39 @@ func main() {
 	signal.Notify(c, os.Interrupt)
 	signal.Notify(c, syscall.SIGTERM)
 
-	mux := http.NewServeMux()
-	for _, repo := range repos {
-		mux.HandleFunc(fmt.Sprintf("/events/%s/%s", repo.Owner, repo.Name), prHandler(repo, client))
+	j, err := journal.Open(filepath.Join(dataDir, "journal.json"))
+	if err != nil {
+		log.Fatalf("opening journal failed: %v", err)
+	}
+
+	sup := newSupervisor(creds, host, publicDNS, secret, j)
+	sup.identity = identity
+	installed := make(chan struct{}, 1)
+	if app != nil {
+		sup.app = true
+		sup.router.Handle(appEventPath, appWebhook{secret: string(secret.key), router: sup.router, installed: installed})
 	}
 	srv := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: sup.router,
 	}
 	log.Printf("Listening on %q\n", addr)
 	go func() {
 		srv.ListenAndServe()
 	}()
 
-	var h *github.Hook
-	if publicDNS != "" {
-		for i, repo := range repos {
-			h, err = registerHook(client, publicDNS, repo.Owner, repo.Name)
-			if err != nil {
-				log.Fatal(err)
-			}
-			repos[i].hook = h
-		}
+	if err := sup.Apply(repos); err != nil {
+		log.Fatal(err)
+	}
+
+	// On SIGHUP, or changes to the configuration file reload repositories.
+	if configPath != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go watchConfig(configPath, 10*time.Second, hup, sup.Apply)
+	}
+	if discover {
+		go watchInstallations(10*time.Minute, installed, discoverRepositories, sup.Apply)
 	}
 
 	sig := <-c
@@ -164,26 +311,27 @@ func main() {
 	srv.Shutdown(ctx)
 	cancel()
 	log.Printf("Received %s, exiting.", sig.String())
-	if h != nil {
-		for _, repo := range repos {
-			client.Repositories.DeleteHook(context.Background(), repo.Owner, repo.Name, *repo.hook.ID)
-		}
-	}
+	sup.Shutdown()
 }
 
-func createHook(client *github.Client, publicDNS, owner, repo, hookTarget string) (*github.Hook, error) {
+func createHook(client *github.Client, publicDNS, owner, repo, hookTarget, secret string, events []string) (*github.Hook, error) {
 	hook, _, err := client.Repositories.CreateHook(context.Background(), owner, repo, &github.Hook{
 		Name:   github.String("web"),
 		Active: github.Bool(true),
-		Config: map[string]interface{}{
-			"url":          hookTarget,
-			"content_type": "json",
-		},
-		Events: []string{"*"},
+		Config: hookConfig(hookTarget, secret),
+		Events: events,
 	})
 	return hook, err
 }
 
+func hookConfig(hookTarget, secret string) map[string]interface{} {
+	return map[string]interface{}{
+		"url":          hookTarget,
+		"content_type": "json",
+		"secret":       secret,
+	}
+}
+
 func lookupHook(client *github.Client, owner, repo, hookTarget string) (*github.Hook, error) {
 	hooks, _, err := client.Repositories.ListHooks(context.Background(), owner, repo, &github.ListOptions{})
 	if err != nil {
@@ -202,7 +350,7 @@ func lookupHook(client *github.Client, owner, repo, hookTarget string) (*github.
 	return h, nil
 }
 
-func registerHook(client *github.Client, publicDNS, owner, repo string) (*github.Hook, error) {
+func registerHook(client *github.Client, publicDNS, owner, repo, secret string, events []string) (*github.Hook, error) {
 	hookTarget := fmt.Sprintf("%s/events/%s/%s", publicDNS, owner, repo)
 	hook, err := lookupHook(client, owner, repo, hookTarget)
 	if err != nil {
@@ -210,10 +358,14 @@ func registerHook(client *github.Client, publicDNS, owner, repo string) (*github
 	}
 
 	if hook == nil {
-		hook, err = createHook(client, publicDNS, owner, repo, hookTarget)
-		if err != nil {
-			return nil, err
-		}
+		return createHook(client, publicDNS, owner, repo, hookTarget, secret, events)
 	}
-	return hook, nil
+
+	// github never returns the secret of a hook, so always update existing hooks
+	// to make sure they sign payloads with the current secret
+	hook, _, err = client.Repositories.EditHook(context.Background(), owner, repo, hook.GetID(), &github.Hook{
+		Config: hookConfig(hookTarget, secret),
+		Events: events,
+	})
+	return hook, err
 }
@@ -1,9 +1,15 @@
 package main
 
+import "testing"
+
 func intVal(i int) *int {
 	return &i
 }
 
+func int64Val(i int64) *int64 {
+	return &i
+}
+
 func stringVal(s string) *string {
 	return &s
 }
@@ -11,3 +17,27 @@ func stringVal(s string) *string {
 func boolVal(b bool) *bool {
 	return &b
 }
+
+func TestRepository_Set(t *testing.T) {
+	for input, expected := range map[string]string{
+		"test/test":                "test/test#master:merge",
+		"test/test#develop":        "test/test#develop:merge",
+		"test/test:squash":         "test/test#master:squash",
+		"test/test#develop:rebase": "test/test#develop:rebase",
+	} {
+		var r repository
+		if err := r.Set(input); err != nil {
+			t.Fatal(err.Error())
+		}
+		if r.String() != expected {
+			t.Errorf("Expected %q to parse as %q, but got %q", input, expected, r.String())
+		}
+	}
+
+	for _, input := range []string{"test", "test/test:octopus"} {
+		var r repository
+		if err := r.Set(input); err == nil {
+			t.Errorf("Expected %q to be invalid", input)
+		}
+	}
+}
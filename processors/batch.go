@@ -0,0 +1,312 @@
+package processors
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+// Stager merges branches onto a staging branch and moves mainline once the
+// staging branch is green
+type Stager interface {
+	Stage(string, []repo.Head) (string, error)
+	FastForward(string) error
+}
+
+// CommitStater evaluates the statuses and check runs of a commit, returning
+// success, pending or the failing state together with a reason
+type CommitStater interface {
+	State(sha string) (string, string, error)
+}
+
+// Batch merges verified pull requests in batches. The next pull requests in
+// line are merged onto a staging branch. Once CI succeeds on the staging branch
+// mainline is fast-forwarded to it. Failing batches are bisected until the
+// offending pull request is found and ejected.
+type Batch struct {
+	r      Repository
+	stager Stager
+	states CommitStater
+	branch string
+	size   int
+
+	// OnEject is called for pull requests which are removed from the queue
+	// because they do not merge cleanly or fail CI
+	OnEject func(*github.PullRequest, error)
+
+	mu    sync.Mutex
+	queue []*github.PullRequest
+	// priority contains pull requests which jump the queue
+	priority map[int]bool
+	// batch is the set of pull requests currently staged at sha
+	batch []*github.PullRequest
+	sha   string
+	// limit shrinks while bisecting a failing batch
+	limit int
+	kick  chan struct{}
+	// failures counts consecutive failures to stage, which are retried after backoff
+	failures int
+	backoff  func(int) time.Duration
+}
+
+// NewBatch returns a batch merger which stages up to size pull requests at once
+func NewBatch(r Repository, stager Stager, states CommitStater, branch string, size int) *Batch {
+	return &Batch{
+		r:        r,
+		stager:   stager,
+		states:   states,
+		branch:   branch,
+		size:     size,
+		limit:    size,
+		priority: map[int]bool{},
+		kick:     make(chan struct{}, 1),
+		backoff:  repo.Backoff,
+	}
+}
+
+func indexOf(prs []*github.PullRequest, number int) int {
+	for i, pr := range prs {
+		if pr.GetNumber() == number {
+			return i
+		}
+	}
+	return -1
+}
+
+// add queues a pull request unless it's queued already
+func (b *Batch) add(pr *github.PullRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if i := indexOf(b.queue, pr.GetNumber()); i >= 0 {
+		b.queue[i] = pr
+		return
+	}
+	// the current batch is not disturbed by prioritized pull requests
+	b.queue = insert(b.queue, pr, b.priority, len(b.batch))
+}
+
+// Prioritize moves a pull request ahead of all other pull requests waiting for
+// the next batch. Pull requests which are not queued yet are prioritized once they are added
+func (b *Batch) Prioritize(pr *github.PullRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.priority[pr.GetNumber()] = true
+	i := indexOf(b.queue, pr.GetNumber())
+	if i < len(b.batch) {
+		return
+	}
+	queued := b.queue[i]
+	b.queue = append(b.queue[:i], b.queue[i+1:]...)
+	b.queue = insert(b.queue, queued, b.priority, len(b.batch))
+}
+
+// Position returns the zero-based position of a pull request, or -1 if it's not queued
+func (b *Batch) Position(pr *github.PullRequest) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return indexOf(b.queue, pr.GetNumber())
+}
+
+// Remove drops a pull request from the queue. If it's part of the current
+// batch the remaining pull requests are staged again
+func (b *Batch) Remove(pr *github.PullRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.priority, pr.GetNumber())
+	i := indexOf(b.queue, pr.GetNumber())
+	if i < 0 {
+		return
+	}
+	b.queue = append(b.queue[:i], b.queue[i+1:]...)
+	if indexOf(b.batch, pr.GetNumber()) < 0 {
+		return
+	}
+	b.batch, b.sha = nil, ""
+	b.restage()
+}
+
+// restage makes Run stage the queue again
+func (b *Batch) restage() {
+	select {
+	case b.kick <- struct{}{}:
+	default:
+	}
+}
+
+// eject removes a pull request from the queue and notifies OnEject
+func (b *Batch) eject(pr *github.PullRequest, reason error) {
+	b.mu.Lock()
+	delete(b.priority, pr.GetNumber())
+	if i := indexOf(b.queue, pr.GetNumber()); i >= 0 {
+		b.queue = append(b.queue[:i], b.queue[i+1:]...)
+	}
+	b.mu.Unlock()
+
+	log.Printf("%s/%s: ejecting pr %d from batch: %v\n", b.r.Owner, b.r.Name, pr.GetNumber(), reason)
+	if b.OnEject != nil {
+		b.OnEject(pr, reason)
+	}
+}
+
+// next returns the pull requests to stage, or nil if a batch is in progress
+func (b *Batch) next() []*github.PullRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.batch != nil || len(b.queue) == 0 {
+		return nil
+	}
+	n := b.limit
+	if n > len(b.queue) {
+		n = len(b.queue)
+	}
+	b.batch = append([]*github.PullRequest{}, b.queue[:n]...)
+	return b.batch
+}
+
+// stage merges the next pull requests onto the staging branch. Pull requests
+// which conflict are ejected
+func (b *Batch) stage() {
+	for {
+		batch := b.next()
+		if batch == nil {
+			return
+		}
+
+		heads := make([]repo.Head, len(batch))
+		for i, pr := range batch {
+			heads[i] = b.r.Head(pr)
+		}
+		sha, err := b.stager.Stage(b.branch, heads)
+
+		b.mu.Lock()
+		current := b.batch != nil
+		if err != nil || !current {
+			b.batch = nil
+		} else {
+			b.sha = sha
+		}
+		if err == nil {
+			b.failures = 0
+		}
+		b.mu.Unlock()
+
+		if !current {
+			// a pull request was removed while staging
+			continue
+		}
+		if conflict, ok := err.(*repo.MergeConflictError); ok {
+			for _, pr := range batch {
+				if b.r.Head(pr).Key() == conflict.Head.Key() {
+					b.eject(pr, err)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			// e.g. fetching or pushing failed. Without a retry the queue stalls until the next event
+			b.mu.Lock()
+			b.failures++
+			delay := b.backoff(b.failures)
+			b.mu.Unlock()
+			log.Printf("%s/%s: failed to stage %s, retrying in %s: %v\n", b.r.Owner, b.r.Name, b.branch, delay, err)
+			time.AfterFunc(delay, b.restage)
+			return
+		}
+		log.Printf("%s/%s: staged %d pull requests on %s at %s\n", b.r.Owner, b.r.Name, len(batch), b.branch, sha)
+		return
+	}
+}
+
+// check evaluates the statuses and check runs of the staging branch and returns
+// the pull requests which were merged into mainline
+func (b *Batch) check(sha string) []*github.PullRequest {
+	b.mu.Lock()
+	batch := b.batch
+	staged := b.sha
+	b.mu.Unlock()
+	if staged == "" || staged != sha {
+		return nil
+	}
+
+	state, reason, err := b.states.State(sha)
+	if err != nil {
+		log.Printf("%s/%s: failed to get status of %s: %v\n", b.r.Owner, b.r.Name, b.branch, err)
+		return nil
+	}
+
+	switch state {
+	case "pending":
+		return nil
+	case "success":
+		err := b.stager.FastForward(sha)
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.batch, b.sha = nil, ""
+		if err != nil {
+			// mainline moved in the meantime; the batch is staged again
+			log.Printf("%s/%s: failed to fast-forward %s: %v\n", b.r.Owner, b.r.Name, b.r.Mainline, err)
+			return nil
+		}
+		for _, pr := range batch {
+			if i := indexOf(b.queue, pr.GetNumber()); i >= 0 {
+				b.queue = append(b.queue[:i], b.queue[i+1:]...)
+			}
+		}
+		b.limit = b.size
+		return batch
+	default:
+		b.mu.Lock()
+		b.batch, b.sha = nil, ""
+		b.limit = b.size
+		if len(batch) > 1 {
+			// bisect by staging the first half of the failing batch
+			b.limit = len(batch) / 2
+		}
+		b.mu.Unlock()
+
+		if len(batch) == 1 {
+			b.eject(batch[0], fmt.Errorf("%s: %s", b.branch, reason))
+		}
+		return nil
+	}
+}
+
+// Run stages queued pull requests whenever the queue changes and emits pull
+// requests once they are merged into mainline. shas receives the commits
+// statuses or checks completed on, the staged commit is evaluated again
+func (b *Batch) Run(input <-chan *github.PullRequest, shas <-chan string) <-chan *github.PullRequest {
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for input != nil || shas != nil {
+			select {
+			case pr, ok := <-input:
+				if !ok {
+					input = nil
+					continue
+				}
+				b.add(pr)
+			case sha, ok := <-shas:
+				if !ok {
+					shas = nil
+					continue
+				}
+				for _, pr := range b.check(sha) {
+					ret <- pr
+				}
+			case <-b.kick:
+			}
+			b.stage()
+		}
+		close(ret)
+	}()
+	return ret
+}
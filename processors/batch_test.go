@@ -0,0 +1,234 @@
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+// fakeStager sends every staged sha on shas. Staging fails with a merge
+// conflict for branches listed in conflicts, and fails the first failures attempts
+type fakeStager struct {
+	shas      chan string
+	conflicts map[string]bool
+	merged    []string
+	failures  int
+}
+
+func newFakeStager(conflicts ...string) *fakeStager {
+	f := &fakeStager{shas: make(chan string, 10), conflicts: map[string]bool{}}
+	for _, c := range conflicts {
+		f.conflicts[c] = true
+	}
+	return f
+}
+
+func (f *fakeStager) Stage(_ string, heads []repo.Head) (string, error) {
+	if f.failures > 0 {
+		f.failures--
+		return "", errors.New("push failed")
+	}
+	branches := make([]string, len(heads))
+	for i, head := range heads {
+		if f.conflicts[head.Ref] {
+			return "", &repo.MergeConflictError{Branch: head.Ref, Head: head}
+		}
+		branches[i] = head.Ref
+	}
+	sha := strings.Join(branches, "+")
+	f.shas <- sha
+	return sha, nil
+}
+
+func (f *fakeStager) FastForward(sha string) error {
+	f.merged = append(f.merged, sha)
+	return nil
+}
+
+// fakeCommitStater fails every staged sha containing a failing branch
+type fakeCommitStater struct {
+	failing string
+}
+
+func (f fakeCommitStater) State(sha string) (string, string, error) {
+	for _, head := range strings.Split(sha, "+") {
+		if head == f.failing {
+			return "failure", fmt.Sprintf("%s failed", head), nil
+		}
+	}
+	return "success", "", nil
+}
+
+func batchPR(number int) *github.PullRequest {
+	return &github.PullRequest{
+		Number: intVal(number),
+		Head: &github.PullRequestBranch{
+			Ref: stringVal(fmt.Sprintf("pr-%d", number)),
+		},
+	}
+}
+
+func TestBatch(t *testing.T) {
+	r := Repository{Owner: "test", Name: "test", Mainline: "master"}
+
+	expectStaged := func(t *testing.T, stager *fakeStager, events chan<- string, expected string) {
+		sha := <-stager.shas
+		if sha != expected {
+			t.Fatalf("Expected %q to be staged, but got %q", expected, sha)
+		}
+		events <- sha
+	}
+	expectMerged := func(t *testing.T, out <-chan *github.PullRequest, expected ...int) {
+		for _, number := range expected {
+			if pr := <-out; pr.GetNumber() != number {
+				t.Fatalf("Expected #%d, but got #%d", number, pr.GetNumber())
+			}
+		}
+	}
+
+	t.Run("fast-forwards green batches", func(t *testing.T) {
+		stager := newFakeStager()
+		batch := NewBatch(r, stager, fakeCommitStater{}, "staging", 2)
+		input := make(chan *github.PullRequest)
+		events := make(chan string)
+		out := batch.Run(input, events)
+
+		for i := 1; i <= 3; i++ {
+			input <- batchPR(i)
+		}
+		expectStaged(t, stager, events, "pr-1")
+		expectMerged(t, out, 1)
+		expectStaged(t, stager, events, "pr-2+pr-3")
+		expectMerged(t, out, 2, 3)
+		close(input)
+		close(events)
+
+		if len(stager.merged) != 2 || stager.merged[0] != "pr-1" || stager.merged[1] != "pr-2+pr-3" {
+			t.Fatalf("Unexpected fast-forwards %v", stager.merged)
+		}
+	})
+
+	t.Run("bisects failing batches", func(t *testing.T) {
+		stager := newFakeStager()
+		batch := NewBatch(r, stager, fakeCommitStater{failing: "pr-3"}, "staging", 4)
+		var ejected []int
+		batch.OnEject = func(pr *github.PullRequest, _ error) {
+			ejected = append(ejected, pr.GetNumber())
+		}
+		input := make(chan *github.PullRequest)
+		events := make(chan string)
+		out := batch.Run(input, events)
+
+		for i := 1; i <= 5; i++ {
+			input <- batchPR(i)
+		}
+		expectStaged(t, stager, events, "pr-1")
+		expectMerged(t, out, 1)
+		expectStaged(t, stager, events, "pr-2+pr-3+pr-4+pr-5")
+		expectStaged(t, stager, events, "pr-2+pr-3")
+		expectStaged(t, stager, events, "pr-2")
+		expectMerged(t, out, 2)
+		expectStaged(t, stager, events, "pr-3+pr-4+pr-5")
+		expectStaged(t, stager, events, "pr-3")
+		expectStaged(t, stager, events, "pr-4+pr-5")
+		expectMerged(t, out, 4, 5)
+		close(input)
+		close(events)
+
+		if len(ejected) != 1 || ejected[0] != 3 {
+			t.Fatalf("Expected #3 to be ejected, but got %v", ejected)
+		}
+	})
+
+	t.Run("ejects conflicting pull requests", func(t *testing.T) {
+		stager := newFakeStager("pr-1")
+		batch := NewBatch(r, stager, fakeCommitStater{}, "staging", 2)
+		var ejected []int
+		batch.OnEject = func(pr *github.PullRequest, _ error) {
+			ejected = append(ejected, pr.GetNumber())
+		}
+		input := make(chan *github.PullRequest)
+		events := make(chan string)
+		out := batch.Run(input, events)
+
+		input <- batchPR(1)
+		input <- batchPR(2)
+		expectStaged(t, stager, events, "pr-2")
+		expectMerged(t, out, 2)
+		close(input)
+		close(events)
+
+		if len(ejected) != 1 || ejected[0] != 1 {
+			t.Fatalf("Expected #1 to be ejected, but got %v", ejected)
+		}
+	})
+
+	t.Run("retries failed stagings", func(t *testing.T) {
+		stager := newFakeStager()
+		stager.failures = 1
+		batch := NewBatch(r, stager, fakeCommitStater{}, "staging", 2)
+		batch.backoff = func(int) time.Duration { return time.Millisecond }
+		input := make(chan *github.PullRequest)
+		events := make(chan string)
+		out := batch.Run(input, events)
+
+		input <- batchPR(1)
+		expectStaged(t, stager, events, "pr-1")
+		expectMerged(t, out, 1)
+		close(input)
+		close(events)
+	})
+
+	t.Run("stages prioritized pull requests next", func(t *testing.T) {
+		stager := newFakeStager()
+		batch := NewBatch(r, stager, fakeCommitStater{}, "staging", 1)
+		input := make(chan *github.PullRequest)
+		events := make(chan string)
+		out := batch.Run(input, events)
+
+		input <- batchPR(1)
+		if sha := <-stager.shas; sha != "pr-1" {
+			t.Fatalf("Expected %q to be staged, but got %q", "pr-1", sha)
+		}
+		input <- batchPR(2)
+		input <- batchPR(3)
+		batch.Prioritize(batchPR(3))
+		if pos := batch.Position(batchPR(3)); pos != 1 {
+			t.Fatalf("Expected #3 at position 1, but got %d", pos)
+		}
+		events <- "pr-1"
+		expectMerged(t, out, 1)
+		expectStaged(t, stager, events, "pr-3")
+		expectMerged(t, out, 3)
+		expectStaged(t, stager, events, "pr-2")
+		expectMerged(t, out, 2)
+		close(input)
+		close(events)
+	})
+
+	t.Run("restages when a staged pull request is removed", func(t *testing.T) {
+		stager := newFakeStager()
+		batch := NewBatch(r, stager, fakeCommitStater{}, "staging", 2)
+		input := make(chan *github.PullRequest)
+		events := make(chan string)
+		out := batch.Run(input, events)
+
+		input <- batchPR(1)
+		if sha := <-stager.shas; sha != "pr-1" {
+			t.Fatalf("Expected %q to be staged, but got %q", "pr-1", sha)
+		}
+		input <- batchPR(2)
+		batch.Remove(batchPR(1))
+		// the previously staged sha is ignored
+		events <- "pr-1"
+		expectStaged(t, stager, events, "pr-2")
+		expectMerged(t, out, 2)
+		close(input)
+		close(events)
+	})
+}
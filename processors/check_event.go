@@ -0,0 +1,78 @@
+package processors
+
+import (
+	"context"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
+)
+
+// succeeded reports if a check conclusion allows merging
+func succeeded(conclusion string) bool {
+	return conclusion == "success" || conclusion == "neutral" || conclusion == "skipped"
+}
+
+// emitOpenPullRequests lists open pull requests and emits those matching the
+// given head sha, or all of them if sha is empty
+func emitOpenPullRequests(repo Repository, client PullRequestLister, sha string, ret chan<- *github.PullRequest) {
+	prs, _, err := client.List(
+		context.Background(),
+		repo.Owner,
+		repo.Name,
+		&github.PullRequestListOptions{
+			State: "open",
+		})
+	if err != nil {
+		return
+	}
+	for _, pr := range prs {
+		if sha == "" || pr.Head.GetSHA() == sha {
+			ret <- pr
+		}
+	}
+}
+
+// CheckRunEvent emits open pull requests when one of their check runs completes.
+// Failed check runs are emitted as well so pull requests going red leave the queue.
+// Runs succeeding on mainline emit all open pull requests, just like mainline status events
+func CheckRunEvent(repo Repository, client PullRequestLister, input <-chan *checks.CheckRunEvent) <-chan *github.PullRequest {
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for evt := range input {
+			if evt.GetAction() != "completed" {
+				continue
+			}
+			if evt.CheckRun.GetCheckSuite().GetHeadBranch() == repo.Mainline {
+				if succeeded(evt.CheckRun.GetConclusion()) {
+					emitOpenPullRequests(repo, client, "", ret)
+				}
+				continue
+			}
+			emitOpenPullRequests(repo, client, evt.CheckRun.GetHeadSHA(), ret)
+		}
+		close(ret)
+	}()
+	return ret
+}
+
+// CheckSuiteEvent emits open pull requests when a check suite of their head completes.
+// Suites completing successfully on mainline emit all open pull requests, just like mainline status events
+func CheckSuiteEvent(repo Repository, client PullRequestLister, input <-chan *checks.CheckSuiteEvent) <-chan *github.PullRequest {
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for evt := range input {
+			if evt.GetAction() != "completed" {
+				continue
+			}
+			if evt.CheckSuite.GetHeadBranch() == repo.Mainline {
+				if succeeded(evt.CheckSuite.GetConclusion()) {
+					emitOpenPullRequests(repo, client, "", ret)
+				}
+				continue
+			}
+			emitOpenPullRequests(repo, client, evt.CheckSuite.GetHeadSHA(), ret)
+		}
+		close(ret)
+	}()
+	return ret
+}
@@ -0,0 +1,143 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
+)
+
+func fakeCheckPullRequests() fakePullRequestLister {
+	return fakePullRequestLister(func() ([]*github.PullRequest, *github.Response, error) {
+		return []*github.PullRequest{
+			{Number: intVal(1), Head: &github.PullRequestBranch{SHA: stringVal("a")}},
+			{Number: intVal(2), Head: &github.PullRequestBranch{SHA: stringVal("b")}},
+		}, nil, nil
+	})
+}
+
+func collect(prs <-chan *github.PullRequest) []int {
+	numbers := []int{}
+	for pr := range prs {
+		numbers = append(numbers, pr.GetNumber())
+	}
+	return numbers
+}
+
+func TestCheckRunEvent(t *testing.T) {
+	r := Repository{Owner: "test", Name: "test", Mainline: "master"}
+	ch := make(chan *checks.CheckRunEvent, 3)
+	prs := CheckRunEvent(r, fakeCheckPullRequests(), ch)
+	ch <- &checks.CheckRunEvent{
+		Action:   stringVal("created"),
+		CheckRun: &checks.CheckRun{HeadSHA: stringVal("a")},
+	}
+	ch <- &checks.CheckRunEvent{
+		Action:   stringVal("completed"),
+		CheckRun: &checks.CheckRun{HeadSHA: stringVal("a"), Conclusion: stringVal("failure")},
+	}
+	ch <- &checks.CheckRunEvent{
+		Action:   stringVal("completed"),
+		CheckRun: &checks.CheckRun{HeadSHA: stringVal("b"), Conclusion: stringVal("success")},
+	}
+	close(ch)
+
+	if numbers := collect(prs); len(numbers) != 2 || numbers[0] != 1 || numbers[1] != 2 {
+		t.Fatalf("Expected #1 and #2 to be emitted, but got %v", numbers)
+	}
+}
+
+func TestCheckRunEvent_Mainline(t *testing.T) {
+	r := Repository{Owner: "test", Name: "test", Mainline: "master"}
+	mainline := &checks.CheckSuite{HeadBranch: stringVal("master")}
+	ch := make(chan *checks.CheckRunEvent, 2)
+	prs := CheckRunEvent(r, fakeCheckPullRequests(), ch)
+	ch <- &checks.CheckRunEvent{
+		Action:   stringVal("completed"),
+		CheckRun: &checks.CheckRun{HeadSHA: stringVal("c"), Conclusion: stringVal("failure"), CheckSuite: mainline},
+	}
+	ch <- &checks.CheckRunEvent{
+		Action:   stringVal("completed"),
+		CheckRun: &checks.CheckRun{HeadSHA: stringVal("c"), Conclusion: stringVal("success"), CheckSuite: mainline},
+	}
+	close(ch)
+
+	if numbers := collect(prs); len(numbers) != 2 {
+		t.Fatalf("Expected all pull requests to be emitted once, but got %v", numbers)
+	}
+}
+
+func TestCheckSuiteEvent(t *testing.T) {
+	r := Repository{Owner: "test", Name: "test", Mainline: "master"}
+
+	t.Run("emits pull requests of the suite", func(t *testing.T) {
+		ch := make(chan *checks.CheckSuiteEvent, 1)
+		prs := CheckSuiteEvent(r, fakeCheckPullRequests(), ch)
+		ch <- &checks.CheckSuiteEvent{
+			Action: stringVal("completed"),
+			CheckSuite: &checks.CheckSuite{
+				HeadBranch: stringVal("feature"),
+				HeadSHA:    stringVal("a"),
+				Conclusion: stringVal("success"),
+			},
+		}
+		close(ch)
+
+		if numbers := collect(prs); len(numbers) != 1 || numbers[0] != 1 {
+			t.Fatalf("Expected #1 to be emitted, but got %v", numbers)
+		}
+	})
+
+	t.Run("emits all pull requests for mainline", func(t *testing.T) {
+		ch := make(chan *checks.CheckSuiteEvent, 1)
+		prs := CheckSuiteEvent(r, fakeCheckPullRequests(), ch)
+		ch <- &checks.CheckSuiteEvent{
+			Action: stringVal("completed"),
+			CheckSuite: &checks.CheckSuite{
+				HeadBranch: stringVal("master"),
+				HeadSHA:    stringVal("c"),
+				Conclusion: stringVal("neutral"),
+			},
+		}
+		close(ch)
+
+		if numbers := collect(prs); len(numbers) != 2 {
+			t.Fatalf("Expected all pull requests to be emitted, but got %v", numbers)
+		}
+	})
+
+	t.Run("emits pull requests of failed suites", func(t *testing.T) {
+		ch := make(chan *checks.CheckSuiteEvent, 1)
+		prs := CheckSuiteEvent(r, fakeCheckPullRequests(), ch)
+		ch <- &checks.CheckSuiteEvent{
+			Action: stringVal("completed"),
+			CheckSuite: &checks.CheckSuite{
+				HeadBranch: stringVal("feature"),
+				HeadSHA:    stringVal("b"),
+				Conclusion: stringVal("failure"),
+			},
+		}
+		close(ch)
+
+		if numbers := collect(prs); len(numbers) != 1 || numbers[0] != 2 {
+			t.Fatalf("Expected #2 to be emitted, but got %v", numbers)
+		}
+	})
+
+	t.Run("ignores failed suites on mainline", func(t *testing.T) {
+		ch := make(chan *checks.CheckSuiteEvent, 1)
+		prs := CheckSuiteEvent(r, fakeCheckPullRequests(), ch)
+		ch <- &checks.CheckSuiteEvent{
+			Action: stringVal("completed"),
+			CheckSuite: &checks.CheckSuite{
+				HeadBranch: stringVal("master"),
+				Conclusion: stringVal("failure"),
+			},
+		}
+		close(ch)
+
+		if numbers := collect(prs); len(numbers) != 0 {
+			t.Fatalf("Expected no pull requests, but got %v", numbers)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
 	"github.com/nicolai86/github-rebase-bot/repo"
 )
 
@@ -18,7 +19,13 @@ type PullRequestLister interface {
 }
 
 type WorkerCache interface {
-	Worker(string) (repo.Enqueuer, error)
+	HeadWorker(repo.Head) (repo.Enqueuer, error)
 	Update() (string, error)
 	Cleanup(repo.GitWorktree) error
 }
+
+// StageRecorder persists the stage of pull requests so processing can resume after a restart
+type StageRecorder interface {
+	Record(*github.PullRequest, journal.Stage) error
+	Remove(*github.PullRequest) error
+}
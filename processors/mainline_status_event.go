@@ -6,7 +6,8 @@ import (
 	"github.com/google/go-github/github"
 )
 
-// MainlineStatusEvent takes mainline status events and emits open PRs
+// MainlineStatusEvent takes mainline status events and emits open PRs. Check runs
+// and suites succeeding on mainline are handled by CheckRunEvent and CheckSuiteEvent
 func MainlineStatusEvent(repo Repository, client PullRequestLister, input <-chan *github.StatusEvent) <-chan *github.PullRequest {
 	ret := make(chan *github.PullRequest)
 	go func() {
@@ -1,36 +1,158 @@
 package processors
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log"
+	"strings"
+	"text/template"
 
 	"github.com/google/go-github/github"
 )
 
+const (
+	// MergeMethodMerge creates a merge commit on mainline
+	MergeMethodMerge = "merge"
+	// MergeMethodSquash squashes all commits of a pull request into a single commit
+	MergeMethodSquash = "squash"
+	// MergeMethodRebase rebases all commits of a pull request onto mainline
+	MergeMethodRebase = "rebase"
+
+	// mergeMethodLabelPrefix marks labels which override the merge method of a single pull request
+	mergeMethodLabelPrefix = "merge:"
+
+	// DefaultSquashTitle is used as squash commit title if a repository does not configure one
+	DefaultSquashTitle = "{{.Title}} (#{{.Number}})"
+	// DefaultSquashBody is used as squash commit body if a repository does not configure one
+	DefaultSquashBody = "{{.Body}}"
+)
+
+// ValidMergeMethod reports if github supports the given merge method
+func ValidMergeMethod(method string) bool {
+	return method == MergeMethodMerge || method == MergeMethodSquash || method == MergeMethodRebase
+}
+
+// mergeMethod returns the merge method for a pull request. A label like merge:squash
+// takes precedence over the repository default
+func mergeMethod(r Repository, labels []github.Label) string {
+	for _, label := range labels {
+		name := strings.ToLower(label.GetName())
+		if !strings.HasPrefix(name, mergeMethodLabelPrefix) {
+			continue
+		}
+		method := strings.TrimPrefix(name, mergeMethodLabelPrefix)
+		if ValidMergeMethod(method) {
+			return method
+		}
+	}
+	if r.MergeMethod != "" {
+		return r.MergeMethod
+	}
+	return MergeMethodMerge
+}
+
+// mergeCommit renders the commit title and message used when merging a pull request
+func mergeCommit(r Repository, pr *github.PullRequest, method string) (string, string, error) {
+	if method != MergeMethodSquash {
+		return "", "merge-bot merged", nil
+	}
+
+	data := struct {
+		Title  string
+		Number int
+		Body   string
+	}{pr.GetTitle(), pr.GetNumber(), pr.GetBody()}
+
+	render := func(text, fallback string) (string, error) {
+		if text == "" {
+			text = fallback
+		}
+		tmpl, err := template.New("commit").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	title, err := render(r.SquashTitle, DefaultSquashTitle)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := render(r.SquashBody, DefaultSquashBody)
+	if err != nil {
+		return "", "", err
+	}
+	return title, body, nil
+}
+
+// DeleteBranch deletes the head branch of a merged pull request. Branches
+// of forks are left alone, they don't belong to the base repository
+func DeleteBranch(r Repository, client *github.Client, pr *github.PullRequest) {
+	if r.IsFork(pr) {
+		return
+	}
+	if _, err := client.Git.DeleteRef(
+		context.Background(),
+		pr.Base.User.GetLogin(),
+		pr.Base.Repo.GetName(),
+		fmt.Sprintf("heads/%s", *pr.Head.Ref),
+	); err != nil {
+		fmt.Printf("Failed deleting branch: %q\n", err)
+	}
+}
+
 // Merge executes a merge to mainline via the github api.
-func Merge(client *github.Client, input <-chan *github.PullRequest) <-chan *github.PullRequest {
+// onFailure is called for every pull request which could not be merged and may be nil
+func Merge(r Repository, client *github.Client, input <-chan *github.PullRequest, onFailure func(*github.PullRequest, error)) <-chan *github.PullRequest {
+	fail := func(pr *github.PullRequest, err error) {
+		log.Printf("%s/%s: pr %d failed to merge: %v\n", r.Owner, r.Name, pr.GetNumber(), err)
+		if onFailure != nil {
+			onFailure(pr, err)
+		}
+	}
+
 	ret := make(chan *github.PullRequest)
 	go func() {
 		for pr := range input {
-			if _, _, err := client.PullRequests.Merge(
+			var labels []github.Label
+			issue, _, err := client.Issues.Get(
 				context.Background(),
 				pr.Base.User.GetLogin(),
 				pr.Base.Repo.GetName(),
 				pr.GetNumber(),
-				"merge-bot merged",
-				&github.PullRequestOptions{
-					MergeMethod: "merge",
-				}); err != nil {
+			)
+			if err == nil {
+				labels = issue.Labels
+			}
+
+			method := mergeMethod(r, labels)
+			title, message, err := mergeCommit(r, pr, method)
+			if err != nil {
+				fail(pr, fmt.Errorf("failed to render commit message: %v", err))
 				continue
 			}
 
-			if _, err := client.Git.DeleteRef(
+			if _, _, err := client.PullRequests.Merge(
 				context.Background(),
 				pr.Base.User.GetLogin(),
 				pr.Base.Repo.GetName(),
-				fmt.Sprintf("heads/%s", *pr.Head.Ref),
-			); err != nil {
-				fmt.Printf("Failed deleting branch: %q\n", err)
+				pr.GetNumber(),
+				message,
+				&github.PullRequestOptions{
+					CommitTitle: title,
+					MergeMethod: method,
+				}); err != nil {
+				fail(pr, err)
+				continue
+			}
+
+			if r.DeleteBranch {
+				DeleteBranch(r, client, pr)
 			}
 
 			ret <- pr
@@ -0,0 +1,123 @@
+package processors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestMergeMethod(t *testing.T) {
+	t.Run("defaults to merge", func(t *testing.T) {
+		if m := mergeMethod(Repository{}, nil); m != MergeMethodMerge {
+			t.Fatalf("Expected %q, but got %q", MergeMethodMerge, m)
+		}
+	})
+
+	t.Run("uses repository default", func(t *testing.T) {
+		if m := mergeMethod(Repository{MergeMethod: MergeMethodRebase}, nil); m != MergeMethodRebase {
+			t.Fatalf("Expected %q, but got %q", MergeMethodRebase, m)
+		}
+	})
+
+	t.Run("prefers label override", func(t *testing.T) {
+		labels := []github.Label{
+			{Name: stringVal("LGTM")},
+			{Name: stringVal("Merge:Squash")},
+		}
+		if m := mergeMethod(Repository{MergeMethod: MergeMethodRebase}, labels); m != MergeMethodSquash {
+			t.Fatalf("Expected %q, but got %q", MergeMethodSquash, m)
+		}
+	})
+
+	t.Run("ignores unknown label overrides", func(t *testing.T) {
+		labels := []github.Label{
+			{Name: stringVal("merge:octopus")},
+		}
+		if m := mergeMethod(Repository{MergeMethod: MergeMethodRebase}, labels); m != MergeMethodRebase {
+			t.Fatalf("Expected %q, but got %q", MergeMethodRebase, m)
+		}
+	})
+}
+
+func TestMergeCommit(t *testing.T) {
+	pr := &github.PullRequest{
+		Number: intVal(12),
+		Title:  stringVal("Add feature"),
+		Body:   stringVal("Long description"),
+	}
+
+	t.Run("renders default squash templates", func(t *testing.T) {
+		title, body, err := mergeCommit(Repository{}, pr, MergeMethodSquash)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if title != "Add feature (#12)" {
+			t.Fatalf("Unexpected title %q", title)
+		}
+		if body != "Long description" {
+			t.Fatalf("Unexpected body %q", body)
+		}
+	})
+
+	t.Run("renders custom squash templates", func(t *testing.T) {
+		title, body, err := mergeCommit(Repository{
+			SquashTitle: "#{{.Number}}: {{.Title}}",
+			SquashBody:  "{{.Body}}\n\nmerged by rebase-bot",
+		}, pr, MergeMethodSquash)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if title != "#12: Add feature" {
+			t.Fatalf("Unexpected title %q", title)
+		}
+		if body != "Long description\n\nmerged by rebase-bot" {
+			t.Fatalf("Unexpected body %q", body)
+		}
+	})
+
+	t.Run("fails on invalid templates", func(t *testing.T) {
+		if _, _, err := mergeCommit(Repository{SquashTitle: "{{.Title"}, pr, MergeMethodSquash); err == nil {
+			t.Fatal("Expected invalid template to fail")
+		}
+	})
+}
+
+func TestMerge_ReportsFailures(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test/test/issues/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"number":1}`))
+	})
+	mux.HandleFunc("/repos/test/test/pulls/1/merge", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"message":"Base branch was modified"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	var failed *github.PullRequest
+	ch := make(chan *github.PullRequest, 1)
+	ch <- &github.PullRequest{
+		Number: intVal(1),
+		Base: &github.PullRequestBranch{
+			User: &github.User{Login: stringVal("test")},
+			Repo: &github.Repository{Name: stringVal("test")},
+		},
+	}
+	close(ch)
+
+	merged := Merge(Repository{Owner: "test", Name: "test"}, client, ch, func(pr *github.PullRequest, err error) {
+		failed = pr
+	})
+	if pr, ok := <-merged; ok {
+		t.Fatalf("Expected no merged pull requests, but got #%d", pr.GetNumber())
+	}
+	if failed.GetNumber() != 1 {
+		t.Fatalf("Expected #1 to be reported as failed, but got %v", failed)
+	}
+}
@@ -3,8 +3,10 @@ package processors
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
 	"github.com/nicolai86/github-rebase-bot/repo"
 )
 
@@ -15,6 +17,21 @@ type RebaseResult struct {
 
 var ErrMainlineChanged = errors.New("mainline changed during rebase")
 
+// Retryable reports if a rebase failed temporarily because mainline could not be
+// fetched, and how long to wait before retrying. Such pull requests are parked
+// instead of being blocked
+func Retryable(err error) (time.Duration, bool) {
+	updateErr, ok := err.(*repo.UpdateError)
+	if !ok {
+		return 0, false
+	}
+	delay := time.Until(updateErr.RetryAt)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
 // Rebase rebases a pull request with mainline.
 // if the rebase is possible the changes are pushed to github.
 // when no rebase was necessary the PR is emitted
@@ -26,7 +43,7 @@ func Rebase(r Repository, in <-chan *github.PullRequest) <-chan RebaseResult {
 
 		for pr := range in {
 			cache := r.Cache
-			w, err := cache.Worker(pr.Head.GetRef())
+			w, err := cache.HeadWorker(r.Head(pr))
 			if err != nil {
 				ret <- RebaseResult{pr, err}
 				continue
@@ -40,6 +57,7 @@ func Rebase(r Repository, in <-chan *github.PullRequest) <-chan RebaseResult {
 
 			c := make(chan repo.Signal, 1)
 			wg.Add(1)
+			r.Record(pr, journal.StageRebasing)
 			w.Enqueue(c)
 			go func(pr *github.PullRequest, rev string) {
 				defer wg.Done()
@@ -58,6 +76,11 @@ func Rebase(r Repository, in <-chan *github.PullRequest) <-chan RebaseResult {
 				}
 				if sig.UpToDate {
 					ret <- RebaseResult{pr, nil}
+					return
+				}
+				r.Record(pr, journal.StagePushed)
+				if r.Pushed != nil {
+					r.Pushed(pr)
 				}
 			}(pr, rev)
 		}
@@ -4,15 +4,33 @@ import (
 	"errors"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
 	"github.com/nicolai86/github-rebase-bot/repo"
 )
 
+type fakeStageRecorder struct {
+	mu     sync.Mutex
+	stages []journal.Stage
+}
+
+func (f *fakeStageRecorder) Record(_ *github.PullRequest, stage journal.Stage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stages = append(f.stages, stage)
+	return nil
+}
+
+func (f *fakeStageRecorder) Remove(_ *github.PullRequest) error {
+	return nil
+}
+
 type fakeWorkerCache func(string) (repo.Enqueuer, error)
 
-func (f fakeWorkerCache) Worker(branch string) (repo.Enqueuer, error) {
-	return f(branch)
+func (f fakeWorkerCache) HeadWorker(head repo.Head) (repo.Enqueuer, error) {
+	return f(head.Ref)
 }
 
 func (f fakeWorkerCache) Update() (string, error) {
@@ -171,4 +189,43 @@ func TestRebase(t *testing.T) {
 			t.Fatal("Expected pull request to pass through")
 		}
 	})
+	t.Run("records stages of rebased branches", func(t *testing.T) {
+		ch := make(chan *github.PullRequest)
+		recorder := &fakeStageRecorder{}
+		ret := Rebase(Repository{
+			Owner:    "test",
+			Name:     "test",
+			Mainline: "master",
+			Cache: fakeWorkerCache(func(branch string) (repo.Enqueuer, error) {
+				return fakeEnqueuer(func() repo.Signal { return repo.Signal{UpToDate: false} }), nil
+			}),
+			Journal: recorder,
+		}, ch)
+		ch <- &github.PullRequest{
+			Base: &github.PullRequestBranch{
+				Repo: &github.Repository{
+					Name: stringVal("test"),
+					Owner: &github.User{
+						Login: stringVal("test"),
+					},
+				},
+			},
+		}
+		close(ch)
+		<-ret
+
+		if len(recorder.stages) != 2 || recorder.stages[0] != journal.StageRebasing || recorder.stages[1] != journal.StagePushed {
+			t.Fatalf("Expected stages rebasing and pushed, but got %v", recorder.stages)
+		}
+	})
+}
+
+func TestRetryable(t *testing.T) {
+	delay, ok := Retryable(&repo.UpdateError{RetryAt: time.Now().Add(time.Minute)})
+	if !ok || delay <= 0 || delay > time.Minute {
+		t.Fatalf("Expected failed updates to be retried within a minute, but got %v, %s", ok, delay)
+	}
+	if _, ok := Retryable(errors.New("conflict")); ok {
+		t.Fatal("Expected other errors not to be retried")
+	}
 }
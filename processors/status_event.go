@@ -6,13 +6,18 @@ import (
 	"github.com/google/go-github/github"
 )
 
-// StatusEvent emits pull requests when activity occurs on the specific branch
+// StatusContext is the context of the commit status reported by the bot
+const StatusContext = "rebase-bot"
+
+// StatusEvent emits pull requests when a status of their branch succeeds or fails.
+// Failures are emitted as well so pull requests going red leave the queue. The
+// statuses reported by the bot itself are ignored, they never change the verdict
 func StatusEvent(client PullRequestLister, input <-chan *github.StatusEvent) <-chan *github.PullRequest {
 	ret := make(chan *github.PullRequest)
 
 	go func() {
 		for evt := range input {
-			if evt.GetState() != "success" {
+			if evt.GetState() == "pending" || evt.GetContext() == StatusContext {
 				continue
 			}
 
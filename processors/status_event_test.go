@@ -37,7 +37,7 @@ func fakePullRequestResponse(n int) fakePullRequestLister {
 }
 
 func TestStatusEvent_Filters(t *testing.T) {
-	for _, state := range []string{"pending", "failure", "error"} {
+	for _, state := range []string{"pending"} {
 		t.Run(fmt.Sprintf("%s status", state), func(t *testing.T) {
 			ch := make(chan *github.StatusEvent, 1)
 
@@ -59,6 +59,30 @@ func TestStatusEvent_Filters(t *testing.T) {
 		})
 	}
 
+	t.Run("own statuses", func(t *testing.T) {
+		ch := make(chan *github.StatusEvent, 1)
+
+		prs := StatusEvent(fakePullRequestResponse(1), ch)
+		ch <- &github.StatusEvent{
+			State:   stringVal("failure"),
+			Context: stringVal(StatusContext),
+			Branches: []*github.Branch{
+				{Name: stringVal("test")},
+			},
+			Repo: &github.Repository{
+				Name: stringVal("test"),
+				Owner: &github.User{
+					Login: stringVal("test"),
+				},
+			},
+		}
+		close(ch)
+
+		if v, ok := (<-prs); ok || v != nil {
+			t.Error("Expected statuses of the bot to be filtered")
+		}
+	})
+
 	t.Run("closed pull-requests", func(t *testing.T) {
 		ch := make(chan *github.StatusEvent, 1)
 
@@ -86,24 +110,28 @@ func TestStatusEvent_Filters(t *testing.T) {
 }
 
 func TestStatusEvent_PassThrough(t *testing.T) {
-	ch := make(chan *github.StatusEvent, 1)
-
-	prs := StatusEvent(fakePullRequestResponse(1), ch)
-	ch <- &github.StatusEvent{
-		State: stringVal("success"),
-		Branches: []*github.Branch{
-			{Name: stringVal("test")},
-		},
-		Repo: &github.Repository{
-			Name: stringVal("test"),
-			Owner: &github.User{
-				Login: stringVal("test"),
-			},
-		},
-	}
-	close(ch)
+	for _, state := range []string{"success", "failure", "error"} {
+		t.Run(fmt.Sprintf("%s status", state), func(t *testing.T) {
+			ch := make(chan *github.StatusEvent, 1)
+
+			prs := StatusEvent(fakePullRequestResponse(1), ch)
+			ch <- &github.StatusEvent{
+				State: stringVal(state),
+				Branches: []*github.Branch{
+					{Name: stringVal("test")},
+				},
+				Repo: &github.Repository{
+					Name: stringVal("test"),
+					Owner: &github.User{
+						Login: stringVal("test"),
+					},
+				},
+			}
+			close(ch)
 
-	if v, ok := (<-prs); !ok || v == nil {
-		t.Error("Expected success status /w open PR to pass")
+			if v, ok := (<-prs); !ok || v == nil {
+				t.Errorf("Expected %s status /w open PR to pass", state)
+			}
+		})
 	}
 }
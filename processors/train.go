@@ -0,0 +1,120 @@
+package processors
+
+import (
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// Train serializes merges of a repository. Verified pull requests are queued
+// in order and only the head of the queue is passed on to be rebased and built.
+// The queue advances once the head is merged or removed.
+type Train struct {
+	mu    sync.Mutex
+	queue []*github.PullRequest
+	// priority contains pull requests which jump the queue
+	priority map[int]bool
+}
+
+// NewTrain returns an empty merge train
+func NewTrain() *Train {
+	return &Train{priority: map[int]bool{}}
+}
+
+// insert adds a pull request to a queue. Prioritized pull requests are
+// inserted after other prioritized pull requests, but never before first
+func insert(queue []*github.PullRequest, pr *github.PullRequest, priority map[int]bool, first int) []*github.PullRequest {
+	if !priority[pr.GetNumber()] {
+		return append(queue, pr)
+	}
+	i := first
+	if i > len(queue) {
+		i = len(queue)
+	}
+	for i < len(queue) && priority[queue[i].GetNumber()] {
+		i++
+	}
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = pr
+	return queue
+}
+
+func (t *Train) index(number int) int {
+	for i, pr := range t.queue {
+		if pr.GetNumber() == number {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add queues a pull request unless it's queued already and reports if
+// the pull request is the head of the queue
+func (t *Train) Add(pr *github.PullRequest) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if i := t.index(pr.GetNumber()); i >= 0 {
+		t.queue[i] = pr
+		return i == 0
+	}
+	// the head might be rebasing already, so prioritized pull requests are queued behind it
+	t.queue = insert(t.queue, pr, t.priority, 1)
+	return t.index(pr.GetNumber()) == 0
+}
+
+// Prioritize moves a pull request right behind the head of the queue.
+// Pull requests which are not queued yet are prioritized once they are added
+func (t *Train) Prioritize(pr *github.PullRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.priority[pr.GetNumber()] = true
+	i := t.index(pr.GetNumber())
+	if i <= 0 {
+		return
+	}
+	queued := t.queue[i]
+	t.queue = append(t.queue[:i], t.queue[i+1:]...)
+	t.queue = insert(t.queue, queued, t.priority, 1)
+}
+
+// Remove drops a pull request from the queue. If the head was removed the
+// new head is returned so it can be re-evaluated
+func (t *Train) Remove(pr *github.PullRequest) *github.PullRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.priority, pr.GetNumber())
+	i := t.index(pr.GetNumber())
+	if i < 0 {
+		return nil
+	}
+	t.queue = append(t.queue[:i], t.queue[i+1:]...)
+	if i != 0 || len(t.queue) == 0 {
+		return nil
+	}
+	return t.queue[0]
+}
+
+// Position returns the zero-based position of a pull request, or -1 if it's not queued
+func (t *Train) Position(pr *github.PullRequest) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.index(pr.GetNumber())
+}
+
+// Filter queues all pull requests and only emits the head of the queue
+func (t *Train) Filter(input <-chan *github.PullRequest) <-chan *github.PullRequest {
+	ret := make(chan *github.PullRequest)
+	go func() {
+		for pr := range input {
+			if t.Add(pr) {
+				ret <- pr
+			}
+		}
+		close(ret)
+	}()
+	return ret
+}
@@ -0,0 +1,76 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestTrain(t *testing.T) {
+	t.Run("only emits the head of the queue", func(t *testing.T) {
+		train := NewTrain()
+		ch := make(chan *github.PullRequest, 3)
+		out := train.Filter(ch)
+		ch <- &github.PullRequest{Number: intVal(1)}
+		ch <- &github.PullRequest{Number: intVal(2)}
+		ch <- &github.PullRequest{Number: intVal(1)}
+		close(ch)
+
+		if pr := <-out; pr.GetNumber() != 1 {
+			t.Fatalf("Expected #1, but got #%d", pr.GetNumber())
+		}
+		if pr := <-out; pr.GetNumber() != 1 {
+			t.Fatalf("Expected #1 again, but got #%d", pr.GetNumber())
+		}
+		if _, ok := <-out; ok {
+			t.Fatal("Expected #2 to be held back")
+		}
+		if pos := train.Position(&github.PullRequest{Number: intVal(2)}); pos != 1 {
+			t.Fatalf("Expected #2 at position 1, but got %d", pos)
+		}
+	})
+
+	t.Run("advances when the head is removed", func(t *testing.T) {
+		train := NewTrain()
+		train.Add(&github.PullRequest{Number: intVal(1)})
+		train.Add(&github.PullRequest{Number: intVal(2)})
+		train.Add(&github.PullRequest{Number: intVal(3)})
+
+		if head := train.Remove(&github.PullRequest{Number: intVal(2)}); head != nil {
+			t.Fatalf("Expected no new head, but got #%d", head.GetNumber())
+		}
+		head := train.Remove(&github.PullRequest{Number: intVal(1)})
+		if head == nil || head.GetNumber() != 3 {
+			t.Fatalf("Expected #3 to become head, but got %v", head)
+		}
+		if !train.Add(&github.PullRequest{Number: intVal(3)}) {
+			t.Fatal("Expected #3 to be head")
+		}
+	})
+
+	t.Run("prioritized pull requests queue behind the head", func(t *testing.T) {
+		train := NewTrain()
+		train.Add(&github.PullRequest{Number: intVal(1)})
+		train.Add(&github.PullRequest{Number: intVal(2)})
+		train.Add(&github.PullRequest{Number: intVal(3)})
+		train.Prioritize(&github.PullRequest{Number: intVal(3)})
+		train.Prioritize(&github.PullRequest{Number: intVal(4)})
+		train.Add(&github.PullRequest{Number: intVal(4)})
+
+		for number, expected := range map[int]int{1: 0, 3: 1, 4: 2, 2: 3} {
+			if pos := train.Position(&github.PullRequest{Number: intVal(number)}); pos != expected {
+				t.Fatalf("Expected #%d at position %d, but got %d", number, expected, pos)
+			}
+		}
+	})
+
+	t.Run("ignores unknown pull requests", func(t *testing.T) {
+		train := NewTrain()
+		if head := train.Remove(&github.PullRequest{Number: intVal(1)}); head != nil {
+			t.Fatalf("Expected no head, but got #%d", head.GetNumber())
+		}
+		if pos := train.Position(&github.PullRequest{Number: intVal(1)}); pos != -1 {
+			t.Fatalf("Expected position -1, but got %d", pos)
+		}
+	})
+}
@@ -1,8 +1,88 @@
 package processors
 
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
 type Repository struct {
 	Owner    string
 	Name     string
 	Mainline string
 	Cache    WorkerCache
+
+	// MergeMethod is one of merge, squash or rebase. Defaults to merge
+	MergeMethod string
+	// SquashTitle and SquashBody are templates for squash commits.
+	// They have access to the Title, Number and Body of the pull request
+	SquashTitle string
+	SquashBody  string
+	// DeleteBranch deletes the head branch of a pull request once it's merged
+	DeleteBranch bool
+	// Journal records the progress of pull requests. Optional
+	Journal StageRecorder
+	// Pushed is called after a rebased pull request was pushed. Optional
+	Pushed func(*github.PullRequest)
+	// ForkURL returns the url to push to a fork, given its full name. Optional
+	ForkURL func(string) string
+}
+
+// IsFork reports if the head branch of a pull request lives in another repository.
+// Github omits the head repository once a fork was deleted, those are forks as well
+func (r Repository) IsFork(pr *github.PullRequest) bool {
+	if pr.Head == nil {
+		return false
+	}
+	if pr.Head.Repo == nil {
+		return true
+	}
+	return !strings.EqualFold(pr.Head.Repo.GetFullName(), fmt.Sprintf("%s/%s", r.Owner, r.Name))
+}
+
+// IsDeletedFork reports if the head repository of a pull request was deleted
+func (r Repository) IsDeletedFork(pr *github.PullRequest) bool {
+	return pr.Head != nil && pr.Head.Repo == nil
+}
+
+// Head describes the head branch of a pull request for the worker cache
+func (r Repository) Head(pr *github.PullRequest) repo.Head {
+	head := repo.Head{Number: pr.GetNumber(), Repo: fmt.Sprintf("%s/%s", r.Owner, r.Name), Ref: pr.Head.GetRef()}
+	if pr.Head != nil && pr.Head.Repo != nil {
+		head.Repo = pr.Head.Repo.GetFullName()
+	}
+	switch {
+	case r.IsDeletedFork(pr):
+		head.Deleted = true
+	case r.IsFork(pr):
+		head.URL = pr.Head.Repo.GetCloneURL()
+		if r.ForkURL != nil {
+			head.URL = r.ForkURL(pr.Head.Repo.GetFullName())
+		}
+	}
+	return head
+}
+
+// Record stores the stage of a pull request if the repository has a journal
+func (r Repository) Record(pr *github.PullRequest, stage journal.Stage) {
+	if r.Journal == nil {
+		return
+	}
+	if err := r.Journal.Record(pr, stage); err != nil {
+		log.Printf("%s/%s: pr %d failed to record stage %s: %v\n", r.Owner, r.Name, pr.GetNumber(), stage, err)
+	}
+}
+
+// Forget removes a pull request from the journal of the repository
+func (r Repository) Forget(pr *github.PullRequest) {
+	if r.Journal == nil {
+		return
+	}
+	if err := r.Journal.Remove(pr); err != nil {
+		log.Printf("%s/%s: pr %d failed to remove from journal: %v\n", r.Owner, r.Name, pr.GetNumber(), err)
+	}
 }
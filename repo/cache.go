@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/nicolai86/github-rebase-bot/repo/internal/cmd"
 	"github.com/nicolai86/github-rebase-bot/repo/internal/log"
@@ -20,7 +21,23 @@ type Cache struct {
 	mu       sync.Mutex
 	mainline string
 
+	// strategy and updater control how workers update their branch
+	strategy UpdateStrategy
+	updater  BranchUpdater
+
 	workers map[string]*Worker
+
+	// health tracks failing updates of mainline, so they are retried with backoff
+	health Health
+
+	// credentials authenticate git with the remote, if any
+	credentials Credentials
+	// askPass is the path of the helper answering git's credential prompts
+	askPass string
+	// gnupgHome is the keyring of the gpg signing key, if any
+	gnupgHome string
+	// provenance controls what rebased commits record about their originals
+	provenance Provenance
 }
 
 func (c *Cache) Mainline() string {
@@ -28,15 +45,27 @@ func (c *Cache) Mainline() string {
 }
 
 func (c *Cache) inCacheDirectory() func(*exec.Cmd) {
+	return c.inWorktree(c.dir)
+}
+
+// inWorktree runs git commands in dir, authenticated with the credentials of the cache
+func (c *Cache) inWorktree(dir string) func(*exec.Cmd) {
 	return func(cmd *exec.Cmd) {
-		cmd.Dir = c.dir
+		cmd.Dir = dir
+		cmd.Env = c.environ()
 	}
 }
 
+// Update fetches the latest mainline and returns its sha. Failing updates return
+// an *UpdateError, and further updates fail fast until the error's RetryAt passed
 func (c *Cache) Update() (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if !c.health.Healthy && time.Now().Before(c.health.RetryAt) {
+		return "", c.updateError()
+	}
+
 	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
 		cmd.MustConfigure(exec.Command("git", "fetch", "--all"), c.inCacheDirectory()),
 		cmd.MustConfigure(exec.Command("git", "reset", "--hard", fmt.Sprintf("origin/%s", c.mainline)), c.inCacheDirectory()),
@@ -46,25 +75,77 @@ func (c *Cache) Update() (string, error) {
 	log.PrintLinesPrefixed(c.mainline, stdout)
 	log.PrintLinesPrefixed(c.mainline, stderr)
 	if err != nil {
-		log.Fatalf("Failed to update cache for %s: %q", c.mainline, err)
+		c.health.Healthy = false
+		c.health.Failures++
+		c.health.LastError = err
+		c.health.RetryAt = time.Now().Add(Backoff(c.health.Failures))
+		log.Printf("Failed to update cache for %s: %v", c.mainline, c.updateError())
+		return "", c.updateError()
+	}
+	if c.health.Failures > 0 {
+		log.Printf("Updating cache for %s recovered after %d failures", c.mainline, c.health.Failures)
 	}
+	c.health = Health{Healthy: true}
 
 	lines := strings.Split(stdout, "\n")
 	rev := lines[len(lines)-2]
 	return rev, nil
 }
 
+func (c *Cache) updateError() *UpdateError {
+	return &UpdateError{
+		Mainline: c.mainline,
+		Failures: c.health.Failures,
+		RetryAt:  c.health.RetryAt,
+		Err:      c.health.LastError,
+	}
+}
+
+// Health reports if the cache is able to update mainline
+func (c *Cache) Health() Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.health
+}
+
+// updateSettings returns how workers update their branch. Workers read them
+// for every rebase, so reloaded settings apply to existing workers as well
+func (c *Cache) updateSettings() (UpdateStrategy, BranchUpdater, Provenance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.strategy, c.updater, c.provenance
+}
+
 func (c *Cache) remove(w *Worker) {
-	delete(c.workers, w.branch)
+	delete(c.workers, w.head.Key())
 }
 
 // Prepare clones the given branch from github and returns a Cache
 func Prepare(url, branch string) (*Cache, error) {
+	return PrepareWithCredentials(url, branch, nil)
+}
+
+// PrepareWithCredentials clones the given branch and authenticates all git commands
+// with credentials. url must not contain credentials, so they never end up on disk
+func PrepareWithCredentials(url, branch string, credentials Credentials) (*Cache, error) {
 	dir, err := ioutil.TempDir("", branch)
 	if err != nil {
 		return nil, err
 	}
 
+	c := &Cache{
+		dir:         dir,
+		mainline:    branch,
+		workers:     make(map[string]*Worker),
+		health:      Health{Healthy: true},
+		credentials: credentials,
+	}
+	if credentials != nil {
+		if c.askPass, err = writeAskPass(); err != nil {
+			return nil, err
+		}
+	}
+
 	cmd := exec.Command(
 		"git",
 		"clone",
@@ -73,25 +154,30 @@ func Prepare(url, branch string) (*Cache, error) {
 		branch,
 		dir,
 	)
+	cmd.Env = c.environ()
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	if err := cmd.Run(); err != nil {
+		c.removeAskPass()
 		return nil, err
 	}
-
-	return &Cache{
-		dir:      dir,
-		mainline: branch,
-		workers:  make(map[string]*Worker),
-	}, nil
+	return c, nil
 }
 
+// GitWorktree identifies the worktree of a worker. Key is unique per pull request,
+// Branch names the local branch checked out in the worktree
 type GitWorktree interface {
+	Key() string
 	Branch() string
 }
 
+// StringGitWorktree identifies the worktree of a branch which is not a pull request
 type StringGitWorktree string
 
+func (w StringGitWorktree) Key() string {
+	return string(w)
+}
+
 func (w StringGitWorktree) Branch() string {
 	return string(w)
 }
@@ -138,12 +224,12 @@ func removeWorktreeBranch(dir, branch string) error {
 func (c *Cache) Cleanup(v GitWorktree) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	w, ok := c.workers[v.Branch()]
+	w, ok := c.workers[v.Key()]
 	if !ok {
 		return nil
 	}
 
-	removeWorktreeBranch(c.cacheDirectory(), v.Branch())
+	removeWorktreeBranch(c.cacheDirectory(), w.branch)
 
 	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
 		cmd.MustConfigure(exec.Command("git", "worktree", "prune"), c.inCacheDirectory()),
@@ -154,32 +240,56 @@ func (c *Cache) Cleanup(v GitWorktree) error {
 		log.Printf("worktree cleanup failed: %q", err)
 	}
 	w.stop()
-	delete(c.workers, v.Branch())
+	delete(c.workers, v.Key())
 	return nil
 }
 
+// Close stops all workers, removes their worktrees and deletes the checkout.
+// The cache must not be used afterwards
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	heads := []Head{}
+	for _, w := range c.workers {
+		heads = append(heads, w.head)
+	}
+	c.mu.Unlock()
+
+	for _, head := range heads {
+		c.Cleanup(head)
+	}
+	c.removeAskPass()
+	c.removeGPGHome()
+	return os.RemoveAll(c.dir)
+}
+
 func (c *Cache) cacheDirectory() string {
 	return c.dir
 }
 
-// Worker manages workers for branches. By default a worker runs in its own
-// goroutine and is re-used if the same branch is requested multiple times
+// Worker manages workers for branches of the base repository
 func (c *Cache) Worker(branch string) (Enqueuer, error) {
+	return c.HeadWorker(Head{Ref: branch})
+}
+
+// HeadWorker manages workers for pull requests. By default a worker runs in its own
+// goroutine and is re-used if the same pull request is requested multiple times
+func (c *Cache) HeadWorker(head Head) (Enqueuer, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	w, ok := c.workers[branch]
+	w, ok := c.workers[head.Key()]
 	if ok {
 		return w, nil
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	w = &Worker{
-		branch: branch,
+		branch: head.Branch(),
+		head:   head,
 		cache:  c,
 		queue:  make(chan chan Signal),
 		stop:   cancel,
 	}
-	c.workers[branch] = w
+	c.workers[head.Key()] = w
 
 	rebaser := branchRebaser{
 		w:     w,
@@ -11,6 +11,7 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 func getSHA(dir string) (string, error) {
@@ -24,6 +25,10 @@ func getSHA(dir string) (string, error) {
 	return strings.TrimSpace(b.String()), nil
 }
 
+// testIdentity commits inside test repositories, so tests don't depend on the
+// global git config of the host
+var testIdentity = Identity{Name: "test", Email: "test@example.com"}
+
 func setupTestScenario() (string, error) {
 	tmp, err := ioutil.TempDir("", "cache")
 	if err != nil {
@@ -32,9 +37,26 @@ func setupTestScenario() (string, error) {
 	if err := extract(tmp, "../scenarios/rebase-conflict.zip"); err != nil {
 		return "", err
 	}
+	for _, config := range [][]string{{"user.name", testIdentity.Name}, {"user.email", testIdentity.Email}} {
+		if err := exec.Command("git", "-C", tmp, "config", "--local", config[0], config[1]).Run(); err != nil {
+			return "", err
+		}
+	}
 	return tmp, nil
 }
 
+// prepareTestCache clones a test scenario and configures testIdentity inside the clone
+func prepareTestCache(url string) (*Cache, error) {
+	cache, err := Prepare(url, "master")
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.UseIdentity(testIdentity); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
 func extract(target, src string) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
@@ -112,7 +134,7 @@ func TestCache_update(t *testing.T) {
 	}
 
 	t.Run("checks out latest version", func(t *testing.T) {
-		cache, err := Prepare(tmp, "master")
+		cache, err := prepareTestCache(tmp)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
@@ -133,7 +155,7 @@ func TestCache_update(t *testing.T) {
 	})
 
 	t.Run("updates local copy /w remote changes", func(t *testing.T) {
-		cache, err := Prepare(tmp, "master")
+		cache, err := prepareTestCache(tmp)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
@@ -159,6 +181,109 @@ func TestCache_update(t *testing.T) {
 			t.Fatal("Expected update to work, but didn't")
 		}
 	})
+
+	t.Run("backs off while fetching fails", func(t *testing.T) {
+		cache, err := prepareTestCache(tmp)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer os.RemoveAll(cache.dir)
+
+		setURL := func(url string) {
+			cmd := exec.Command("git", "remote", "set-url", "origin", url)
+			cmd.Dir = cache.dir
+			if err := cmd.Run(); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		setURL(path.Join(tmp, "missing"))
+
+		for i := 0; i < 2; i++ {
+			_, err := cache.Update()
+			updateErr, ok := err.(*UpdateError)
+			if !ok {
+				t.Fatalf("Expected an update error, but got %v", err)
+			}
+			// the second update fails fast without fetching again
+			if updateErr.Failures != 1 || !updateErr.RetryAt.After(time.Now()) {
+				t.Fatalf("Expected one failure with a retry in the future, but got %v", updateErr)
+			}
+		}
+		if cache.Health().Healthy {
+			t.Fatal("Expected cache to be unhealthy, but wasn't")
+		}
+
+		setURL(tmp)
+		cache.health.RetryAt = time.Now()
+		if _, err := cache.Update(); err != nil {
+			t.Fatal(err.Error())
+		}
+		if health := cache.Health(); !health.Healthy || health.Failures != 0 {
+			t.Fatalf("Expected cache to recover, but got %v", health)
+		}
+	})
+}
+
+func TestCache_credentials(t *testing.T) {
+	tmp, err := setupTestScenario()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+
+	token := "first"
+	cache, err := PrepareWithCredentials(tmp, "master", func() (string, error) { return token, nil })
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	askPass := func(prompt string) string {
+		cmd := exec.Command(cache.askPass, prompt)
+		cmd.Env = cache.environ()
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if user := askPass("Username for 'https://github.com': "); user != "x-access-token" {
+		t.Fatalf("Expected x-access-token, but got %q", user)
+	}
+	if password := askPass("Password for 'https://x-access-token@github.com': "); password != "first" {
+		t.Fatalf("Expected the token, but got %q", password)
+	}
+
+	// rotated tokens are used right away
+	token = "second"
+	if password := askPass("Password for 'https://x-access-token@github.com': "); password != "second" {
+		t.Fatalf("Expected the rotated token, but got %q", password)
+	}
+
+	config, err := ioutil.ReadFile(path.Join(cache.dir, ".git", "config"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Contains(string(config), "first") || strings.Contains(string(config), "second") {
+		t.Fatalf("Expected no token inside the clone, but got %s", config)
+	}
+
+	cache.Close()
+	if _, err := os.Stat(cache.askPass); !os.IsNotExist(err) {
+		t.Fatalf("Expected %q to be removed, but wasn't", cache.askPass)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for failures, expected := range map[int]time.Duration{
+		1:  updateBackoff,
+		2:  2 * updateBackoff,
+		3:  4 * updateBackoff,
+		20: maxUpdateBackoff,
+	} {
+		if delay := Backoff(failures); delay != expected {
+			t.Errorf("Expected %s after %d failures, but got %s", expected, failures, delay)
+		}
+	}
 }
 
 func TestCache_Cleanup(t *testing.T) {
@@ -166,7 +291,7 @@ func TestCache_Cleanup(t *testing.T) {
 	if err != nil {
 		t.Fatal(err.Error())
 	}
-	cache, err := Prepare(tmp, "master")
+	cache, err := prepareTestCache(tmp)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -192,6 +317,34 @@ func TestCache_Cleanup(t *testing.T) {
 	})
 }
 
+func TestCache_Close(t *testing.T) {
+	tmp, err := setupTestScenario()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+
+	cache, err := prepareTestCache(tmp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Run("removes workers and checkout", func(t *testing.T) {
+		if _, err := cache.Worker("needs-rebase"); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := cache.Close(); err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(cache.workers) != 0 {
+			t.Fatalf("Expected no workers, but got %d", len(cache.workers))
+		}
+		if _, err := os.Stat(cache.dir); !os.IsNotExist(err) {
+			t.Fatalf("Expected %q to be removed, but wasn't", cache.dir)
+		}
+	})
+}
+
 func TestCache_Worker(t *testing.T) {
 	tmp, err := setupTestScenario()
 	if err != nil {
@@ -199,7 +352,7 @@ func TestCache_Worker(t *testing.T) {
 	}
 
 	t.Run("returns cached worker by branch", func(t *testing.T) {
-		cache, err := Prepare(tmp, "master")
+		cache, err := prepareTestCache(tmp)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
@@ -219,7 +372,7 @@ func TestCache_Worker(t *testing.T) {
 	})
 
 	t.Run("returns new workers by branch", func(t *testing.T) {
-		cache, err := Prepare(tmp, "master")
+		cache, err := prepareTestCache(tmp)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
@@ -238,5 +391,32 @@ func TestCache_Worker(t *testing.T) {
 		}
 	})
 
+	t.Run("returns new workers for pull requests with the same branch", func(t *testing.T) {
+		cache, err := prepareTestCache(tmp)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer os.RemoveAll(cache.dir)
+
+		first := Head{Number: 1, Repo: "alice/example", Ref: "patch-1", URL: "https://github.com/alice/example.git"}
+		second := Head{Number: 2, Repo: "bob/example", Ref: "patch-1", URL: "https://github.com/bob/example.git"}
+		w1, err1 := cache.HeadWorker(first)
+		if err1 != nil {
+			t.Fatal(err1.Error())
+		}
+		w2, err2 := cache.HeadWorker(second)
+		if err2 != nil {
+			t.Fatal(err2.Error())
+		}
+		if w1 == w2 || w1.(*Worker).Branch() == w2.(*Worker).Branch() {
+			t.Fatal("Expected different workers and branches, but got identical ones")
+		}
+
+		cache.Cleanup(first)
+		if _, ok := cache.workers[second.Key()]; !ok || len(cache.workers) != 1 {
+			t.Fatalf("Expected only the closed pull request to be cleaned up, but got %v", cache.workers)
+		}
+	})
+
 	os.RemoveAll(tmp)
 }
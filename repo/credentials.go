@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/nicolai86/github-rebase-bot/repo/internal/log"
+)
+
+// Credentials returns the token git authenticates with. It's looked up for every
+// git command, so rotated tokens are picked up without touching the clone
+type Credentials func() (string, error)
+
+// tokenEnv passes the token to the askpass helper. Unlike arguments the
+// environment of a process is only visible to its user
+const tokenEnv = "REBASE_BOT_GIT_TOKEN"
+
+// askPassScript answers git's credential prompts with the token of tokenEnv
+const askPassScript = `#!/bin/sh
+case "$1" in
+Username*) echo x-access-token ;;
+*) echo "$` + tokenEnv + `" ;;
+esac
+`
+
+// writeAskPass writes the askpass helper to a temporary file
+func writeAskPass() (string, error) {
+	f, err := ioutil.TempFile("", "rebase-bot-askpass")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(askPassScript); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Chmod(0700); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (c *Cache) removeAskPass() {
+	log.Forget(c.dir)
+	if c.askPass != "" {
+		os.Remove(c.askPass)
+	}
+}
+
+// environ returns the environment of git commands. Git never prompts
+// interactively, and asks the askpass helper for credentials if necessary
+func (c *Cache) environ() []string {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if c.gnupgHome != "" {
+		env = append(env, "GNUPGHOME="+c.gnupgHome)
+	}
+	if c.credentials == nil {
+		return env
+	}
+	token, err := c.credentials()
+	if err != nil {
+		log.Printf("looking up credentials for %s failed: %v", c.mainline, err)
+		return env
+	}
+	log.Redact(c.dir, token)
+	return append(env, "GIT_ASKPASS="+c.askPass, tokenEnv+"="+token)
+}
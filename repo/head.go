@@ -0,0 +1,66 @@
+package repo
+
+import "fmt"
+
+// Head identifies the branch of a pull request
+type Head struct {
+	// Number of the pull request. Zero for branches which are not pull requests
+	Number int
+	// Repo is the full name of the head repository, e.g. octocat/hello-world
+	Repo string
+	// Ref is the name of the branch in the head repository
+	Ref string
+	// URL of the head repository if it's a fork, empty for branches of the base repository.
+	// Branches of forks are fetched from the base repository and pushed to URL
+	URL string
+	// Deleted marks forks which were deleted. They are fetched like other forks,
+	// but can't be pushed to
+	Deleted bool
+}
+
+// Fork reports if the branch lives in another repository than mainline
+func (h Head) Fork() bool {
+	return h.URL != "" || h.Deleted
+}
+
+// Key identifies the worker and worktree of a pull request. Branch names alone
+// are ambiguous: forks often use the same names, e.g. patch-1
+func (h Head) Key() string {
+	if h.Number == 0 {
+		return h.Ref
+	}
+	return fmt.Sprintf("%s#%d", h.Repo, h.Number)
+}
+
+// Branch returns the name of the local branch. Branches of pull requests are named
+// after their number, so they can't collide with each other
+func (h Head) Branch() string {
+	if h.Number == 0 {
+		return h.Ref
+	}
+	return fmt.Sprintf("pull/%d", h.Number)
+}
+
+// remoteBranch returns the remote tracking branch the local branch is updated from
+func (h Head) remoteBranch() string {
+	if h.Fork() {
+		return fmt.Sprintf("origin/pull/%d", h.Number)
+	}
+	return fmt.Sprintf("origin/%s", h.Ref)
+}
+
+// fetchSpec returns what to fetch from the base repository to update the remote tracking branch
+func (h Head) fetchSpec() string {
+	if h.Fork() {
+		return fmt.Sprintf("+refs/pull/%d/head:refs/remotes/origin/pull/%d", h.Number, h.Number)
+	}
+	return fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", h.Ref, h.Ref)
+}
+
+// pushRemote returns where the branch is pushed to
+func (h Head) pushRemote() string {
+	if h.Fork() {
+		return h.URL
+	}
+	return "origin"
+}
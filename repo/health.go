@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// updateBackoff is the delay after the first failed update of mainline. It
+	// doubles with every consecutive failure, up to maxUpdateBackoff
+	updateBackoff    = 5 * time.Second
+	maxUpdateBackoff = 5 * time.Minute
+)
+
+// UpdateError is returned when mainline could not be fetched, e.g. because github
+// is unavailable. Updating is retried once RetryAt passed
+type UpdateError struct {
+	Mainline string
+	// Failures counts the consecutive failed updates
+	Failures int
+	RetryAt  time.Time
+	Err      error
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("updating %s failed %d times, retrying at %s: %v", e.Mainline, e.Failures, e.RetryAt.Format(time.RFC3339), e.Err)
+}
+
+// Temporary reports that the update is worth retrying
+func (e *UpdateError) Temporary() bool {
+	return true
+}
+
+// Health describes if a cache is able to update mainline
+type Health struct {
+	Healthy bool
+	// Failures counts the consecutive failed updates
+	Failures int
+	// LastError is the error of the last failed update, if any
+	LastError error
+	// RetryAt is the earliest time the next update is attempted
+	RetryAt time.Time
+}
+
+// Backoff returns the delay before retrying after the given number of consecutive failures
+func Backoff(failures int) time.Duration {
+	delay := updateBackoff
+	for i := 1; i < failures && delay < maxUpdateBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxUpdateBackoff {
+		return maxUpdateBackoff
+	}
+	return delay
+}
@@ -0,0 +1,204 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nicolai86/github-rebase-bot/repo/internal/cmd"
+	"github.com/nicolai86/github-rebase-bot/repo/internal/log"
+)
+
+// SigningFormat selects how commits created by the bot are signed
+type SigningFormat string
+
+const (
+	// SigningGPG signs commits with an armored gpg private key
+	SigningGPG SigningFormat = "gpg"
+	// SigningSSH signs commits with a ssh private key
+	SigningSSH SigningFormat = "ssh"
+)
+
+// ValidSigningFormat reports if s names a signing format
+func ValidSigningFormat(s string) bool {
+	switch SigningFormat(s) {
+	case SigningGPG, SigningSSH:
+		return true
+	}
+	return false
+}
+
+// Provenance controls what rebased commits record about the commits they replace
+type Provenance string
+
+const (
+	// ProvenanceNone makes the bot the committer of rebased commits
+	ProvenanceNone Provenance = ""
+	// ProvenanceCommitter keeps the original committer, dated like the author
+	ProvenanceCommitter Provenance = "committer"
+	// ProvenanceTrailer appends Rebased-by and Original-SHA trailers to rebased commits
+	ProvenanceTrailer Provenance = "trailer"
+)
+
+// ValidProvenance reports if s names a provenance
+func ValidProvenance(s string) bool {
+	switch Provenance(s) {
+	case ProvenanceNone, ProvenanceCommitter, ProvenanceTrailer:
+		return true
+	}
+	return false
+}
+
+// pickedCommit is a shell expression of the original sha of the commit git rebase
+// picked last. Rebases record every executed instruction in rebase-merge/done
+const pickedCommit = `$(grep '^pick ' "$(git rev-parse --git-path rebase-merge/done)" | tail -n 1 | cut -d ' ' -f 2)`
+
+// rebaseArgs returns the arguments of git rebase recording the provenance of
+// rebased commits. Every picked commit is amended right after it was rebased
+func (p Provenance) rebaseArgs() []string {
+	amend := "commit --amend --no-edit --allow-empty --no-verify --quiet"
+	switch p {
+	case ProvenanceCommitter:
+		return []string{"--committer-date-is-author-date", "--exec", fmt.Sprintf(
+			`sha=%s && GIT_COMMITTER_NAME="$(git log -1 --format=%%cn $sha)" GIT_COMMITTER_EMAIL="$(git log -1 --format=%%ce $sha)" GIT_COMMITTER_DATE="$(git log -1 --format=%%cD HEAD)" git %s`,
+			pickedCommit, amend,
+		)}
+	case ProvenanceTrailer:
+		// commits rebased by the bot before keep their Original-SHA, so it
+		// still maps to the reviewed commit after mainline moved again
+		return []string{"--exec", fmt.Sprintf(
+			`sha=%s && git -c trailer.ifexists=replace -c trailer.Original-SHA.ifexists=doNothing %s --trailer "Rebased-by: rebase-bot" --trailer "Original-SHA: $sha"`,
+			pickedCommit, amend,
+		)}
+	}
+	return nil
+}
+
+// Identity is the committer of all commits created by the bot
+type Identity struct {
+	Name  string
+	Email string
+	// SigningKey is the path of the private key commits are signed with, if any.
+	// Keys must not be protected by a passphrase
+	SigningKey    string
+	SigningFormat SigningFormat
+	// Provenance controls what rebased commits record about their originals
+	Provenance Provenance
+}
+
+// gitRequirements returns the oldest git supporting all features used by id
+func (id Identity) gitRequirements() []gitRequirement {
+	var reqs []gitRequirement
+	if id.SigningKey != "" && id.SigningFormat == SigningSSH {
+		reqs = append(reqs, gitRequirement{"ssh signing", gitVersion{2, 34}})
+	}
+	switch id.Provenance {
+	case ProvenanceCommitter:
+		// older versions ignore --committer-date-is-author-date when executing commands
+		reqs = append(reqs, gitRequirement{"committer provenance", gitVersion{2, 29}})
+	case ProvenanceTrailer:
+		reqs = append(reqs, gitRequirement{"trailer provenance", gitVersion{2, 32}})
+	}
+	return reqs
+}
+
+// Validate reports if the installed tools support all features used by id
+func (id Identity) Validate() error {
+	reqs := id.gitRequirements()
+	if len(reqs) == 0 {
+		return nil
+	}
+	v, err := installedGitVersion()
+	if err != nil {
+		return err
+	}
+	if err := checkGit(v, reqs); err != nil {
+		return err
+	}
+	if id.SigningKey != "" && id.SigningFormat == SigningSSH {
+		// git signs with ssh keys by running ssh-keygen
+		if _, err := exec.LookPath("ssh-keygen"); err != nil {
+			return fmt.Errorf("ssh signing requires ssh-keygen: %v", err)
+		}
+	}
+	return nil
+}
+
+// UseIdentity configures the committer inside the clone, so the global git config
+// of the host stays untouched. With a signing key all commits created while
+// rebasing, merging and staging branches are signed
+func (c *Cache) UseIdentity(id Identity) error {
+	if err := id.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.provenance = id.Provenance
+
+	configs := [][]string{
+		{"user.name", id.Name},
+		{"user.email", id.Email},
+		{"commit.gpgsign", fmt.Sprintf("%t", id.SigningKey != "")},
+	}
+	switch {
+	case id.SigningKey == "":
+	case id.SigningFormat == SigningSSH:
+		configs = append(configs, []string{"gpg.format", "ssh"}, []string{"user.signingkey", id.SigningKey})
+	default:
+		fingerprint, err := c.importGPGKey(id.SigningKey)
+		if err != nil {
+			return err
+		}
+		configs = append(configs, []string{"gpg.format", "openpgp"}, []string{"user.signingkey", fingerprint})
+	}
+
+	cmds := []*exec.Cmd{}
+	for _, config := range configs {
+		// without a name or email git falls back to the environment
+		if config[1] == "" {
+			continue
+		}
+		cmds = append(cmds, cmd.MustConfigure(exec.Command("git", "config", "--local", config[0], config[1]), c.inCacheDirectory()))
+	}
+	stdout, stderr, err := cmd.Pipeline(cmds).Run()
+	log.PrintLinesPrefixed(c.mainline, stdout)
+	log.PrintLinesPrefixed(c.mainline, stderr)
+	return err
+}
+
+// importGPGKey imports a private key into a keyring of the cache and returns its
+// fingerprint. The keyring of the host is left untouched as well
+func (c *Cache) importGPGKey(path string) (string, error) {
+	if c.gnupgHome == "" {
+		dir, err := ioutil.TempDir("", "rebase-bot-gnupg")
+		if err != nil {
+			return "", err
+		}
+		c.gnupgHome = dir
+	}
+
+	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("gpg", "--batch", "--import", path), c.inCacheDirectory()),
+		cmd.MustConfigure(exec.Command("gpg", "--batch", "--with-colons", "--list-secret-keys"), c.inCacheDirectory()),
+	}).Run()
+	log.PrintLinesPrefixed(c.mainline, stderr)
+	if err != nil {
+		return "", fmt.Errorf("importing signing key failed: %v", err)
+	}
+	// the first fingerprint belongs to the primary key
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(line, ":")
+		if fields[0] == "fpr" && len(fields) > 9 {
+			return fields[9], nil
+		}
+	}
+	return "", fmt.Errorf("signing key %s contains no secret key", path)
+}
+
+func (c *Cache) removeGPGHome() {
+	if c.gnupgHome != "" {
+		os.RemoveAll(c.gnupgHome)
+	}
+}
@@ -0,0 +1,223 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// signingKey generates an unprotected private key of the given format
+func signingKey(t *testing.T, dir string, format SigningFormat) string {
+	key := path.Join(dir, "key")
+	var cmds []*exec.Cmd
+	switch format {
+	case SigningSSH:
+		cmds = []*exec.Cmd{exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key)}
+	case SigningGPG:
+		home := path.Join(dir, "gnupg")
+		os.Mkdir(home, 0700)
+		cmds = []*exec.Cmd{
+			exec.Command("gpg", "--homedir", home, "--batch", "--pinentry-mode", "loopback", "--passphrase", "", "--quick-gen-key", "rebase bot <rebase-bot@example.com>", "ed25519", "sign"),
+			exec.Command("gpg", "--homedir", home, "--batch", "--armor", "--output", key, "--export-secret-keys"),
+		}
+	}
+	for _, cmd := range cmds {
+		if _, err := exec.LookPath(cmd.Path); err != nil {
+			t.Skipf("%s is not installed", cmd.Path)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+	}
+	return key
+}
+
+func TestCache_UseIdentity(t *testing.T) {
+	for _, format := range []SigningFormat{SigningSSH, SigningGPG} {
+		t.Run(string(format), func(t *testing.T) {
+			tmp, err := setupTestScenario()
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer os.RemoveAll(tmp)
+			keys, err := ioutil.TempDir("", "keys")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer os.RemoveAll(keys)
+
+			cache, err := Prepare(tmp, "master")
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			defer cache.Close()
+			id := Identity{Name: "rebase bot", Email: "rebase-bot@example.com", SigningKey: signingKey(t, keys, format), SigningFormat: format}
+			if err := cache.UseIdentity(id); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			branch := "needs-rebase"
+			v, err := cache.Worker(branch)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			w := v.(*Worker)
+			dir, err := w.prepare()
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if _, err := w.rebase(dir); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			cmd := exec.Command("git", "config", "user.email")
+			cmd.Dir = dir
+			if out, err := cmd.Output(); err != nil || strings.TrimSpace(string(out)) != id.Email {
+				t.Fatalf("Expected the worktree to use %q, but got %q, %v", id.Email, out, err)
+			}
+			cmd = exec.Command("git", "cat-file", "commit", "HEAD")
+			cmd.Dir = dir
+			if out, err := cmd.Output(); err != nil || !strings.Contains(string(out), "gpgsig") {
+				t.Fatalf("Expected the rebased commit to be signed, but got %s, %v", out, err)
+			}
+		})
+	}
+
+	t.Run("leaves the global config untouched", func(t *testing.T) {
+		before, _ := exec.Command("git", "config", "--global", "--list").Output()
+		tmp, err := setupTestScenario()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer os.RemoveAll(tmp)
+		cache, err := Prepare(tmp, "master")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer cache.Close()
+		if err := cache.UseIdentity(Identity{Name: "rebase bot", Email: "rebase-bot@example.com"}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if after, _ := exec.Command("git", "config", "--global", "--list").Output(); string(before) != string(after) {
+			t.Fatalf("Expected the global config to stay untouched, but got %s", after)
+		}
+	})
+}
+
+func TestWorker_provenance(t *testing.T) {
+	tmp, err := setupTestScenario()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+	cache, err := Prepare(tmp, "master")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cache.Close()
+
+	rebase := func(t *testing.T, provenance Provenance, branch string) (string, string) {
+		if err := cache.UseIdentity(Identity{Name: "rebase bot", Email: "rebase-bot@example.com", Provenance: provenance}); err != nil {
+			t.Fatal(err.Error())
+		}
+		v, err := cache.Worker(branch)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w := v.(*Worker)
+		dir, err := w.prepare()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		original, err := revParse(dir, "HEAD")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if ok, err := w.rebase(dir); err != nil || ok {
+			t.Fatalf("Expected %s to be rebased, but got %v, %v", branch, ok, err)
+		}
+		return dir, original
+	}
+	show := func(t *testing.T, dir, format, ref string) string {
+		cmd := exec.Command("git", "log", "-1", "--format="+format, ref)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	t.Run("keeps the original committer", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("needs-rebase"))
+		dir, original := rebase(t, ProvenanceCommitter, "needs-rebase")
+		if committer, expected := show(t, dir, "%cn <%ce>", "HEAD"), show(t, dir, "%cn <%ce>", original); committer != expected {
+			t.Fatalf("Expected committer %q, but got %q", expected, committer)
+		}
+		if date, expected := show(t, dir, "%cD", "HEAD"), show(t, dir, "%aD", "HEAD"); date != expected {
+			t.Fatalf("Expected the committer date to be the author date %q, but got %q", expected, date)
+		}
+	})
+
+	t.Run("appends trailers", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("needs-rebase"))
+		dir, original := rebase(t, ProvenanceTrailer, "needs-rebase")
+		trailers := show(t, dir, "%(trailers:only)", "HEAD")
+		if !strings.Contains(trailers, "Rebased-by: rebase-bot") || !strings.Contains(trailers, "Original-SHA: "+original) {
+			t.Fatalf("Expected trailers referencing %s, but got %q", original, trailers)
+		}
+	})
+
+	t.Run("leaves up to date branches alone", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("up-2-date"))
+		if err := cache.UseIdentity(Identity{Provenance: ProvenanceTrailer}); err != nil {
+			t.Fatal(err.Error())
+		}
+		v, err := cache.Worker("up-2-date")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w := v.(*Worker)
+		dir, err := w.prepare()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if ok, err := w.rebase(dir); err != nil || !ok {
+			t.Fatalf("Expected rebase to not be necessary, but got %v, %v", ok, err)
+		}
+	})
+
+	// moves mainline, so this runs last
+	t.Run("keeps the reviewed sha across rebases", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("needs-rebase"))
+		dir, original := rebase(t, ProvenanceTrailer, "needs-rebase")
+
+		tree := exec.Command("git", "commit-tree", "-p", "master", "-m", "mainline moved", "master^{tree}")
+		tree.Dir = tmp
+		out, err := tree.Output()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if out, err := exec.Command("git", "-C", tmp, "update-ref", "refs/heads/master", strings.TrimSpace(string(out))).CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		if _, err := cache.Update(); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		v, err := cache.Worker("needs-rebase")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if ok, err := v.(*Worker).rebase(dir); err != nil || ok {
+			t.Fatalf("Expected needs-rebase to be rebased again, but got %v, %v", ok, err)
+		}
+		trailers := show(t, dir, "%(trailers:only)", "HEAD")
+		if strings.Count(trailers, "Original-SHA: ") != 1 || !strings.Contains(trailers, "Original-SHA: "+original) {
+			t.Fatalf("Expected a single trailer referencing %s, but got %q", original, trailers)
+		}
+	})
+}
@@ -2,17 +2,56 @@ package log
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	stdLog "log"
+	"regexp"
 	"strings"
+	"sync"
 )
 
+var (
+	mu sync.RWMutex
+	// secrets are replaced in all output, keyed by their owner
+	secrets = map[string]string{}
+	// userinfo matches credentials embedded in urls
+	userinfo = regexp.MustCompile(`(\w+://)[^/@\s]+@`)
+)
+
+// Redact hides the secret of owner in all output logged afterwards. It replaces
+// the previous secret of owner, so rotated secrets don't accumulate
+func Redact(owner, secret string) {
+	if secret == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	secrets[owner] = secret
+}
+
+// Forget stops hiding the secret of owner
+func Forget(owner string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(secrets, owner)
+}
+
+// redact replaces all secrets and credentials in urls of s
+func redact(s string) string {
+	mu.RLock()
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, "***", -1)
+	}
+	mu.RUnlock()
+	return userinfo.ReplaceAllString(s, "${1}***@")
+}
+
 func Printf(format string, a ...interface{}) {
-	stdLog.Printf(format, a...)
+	stdLog.Print(redact(fmt.Sprintf(format, a...)))
 }
 
 func Fatalf(format string, a ...interface{}) {
-	stdLog.Fatalf(format, a...)
+	stdLog.Fatal(redact(fmt.Sprintf(format, a...)))
 }
 
 func PrintLinesPrefixed(prefix, lines string) {
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/nicolai86/github-rebase-bot/repo/internal/cmd"
+	"github.com/nicolai86/github-rebase-bot/repo/internal/log"
+)
+
+// MergeConflictError is returned when a branch does not merge cleanly onto a staging branch
+type MergeConflictError struct {
+	Branch string
+	// Head identifies the pull request of the branch
+	Head Head
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("branch %s does not merge cleanly", e.Branch)
+}
+
+// Stage merges all given branches onto the latest mainline and force pushes the
+// result to branch. It returns the sha of the staged commit. Staging happens in a
+// worktree of its own, so the cache is only locked while adding and pruning it
+func (c *Cache) Stage(branch string, heads []Head) (string, error) {
+	dir, err := ioutil.TempDir("", fmt.Sprintf("%s-%s", path.Base(c.dir), path.Base(branch)))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		stdout, stderr, _ := cmd.Pipeline([]*exec.Cmd{
+			exec.Command("rm", "-fr", dir),
+			cmd.MustConfigure(exec.Command("git", "worktree", "prune"), c.inCacheDirectory()),
+		}).Run()
+		log.PrintLinesPrefixed(branch, stdout)
+		log.PrintLinesPrefixed(branch, stderr)
+	}()
+
+	fetch := []string{"fetch", "origin", "+refs/heads/*:refs/remotes/origin/*"}
+	for _, head := range heads {
+		if head.Fork() {
+			fetch = append(fetch, head.fetchSpec())
+		}
+	}
+	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", fetch...), c.inCacheDirectory()),
+	}).Run()
+	log.PrintLinesPrefixed(branch, stdout)
+	log.PrintLinesPrefixed(branch, stderr)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	stdout, stderr, err = cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", "worktree", "add", "--detach", dir, fmt.Sprintf("origin/%s", c.mainline)), c.inCacheDirectory()),
+	}).Run()
+	c.mu.Unlock()
+	log.PrintLinesPrefixed(branch, stdout)
+	log.PrintLinesPrefixed(branch, stderr)
+	if err != nil {
+		return "", err
+	}
+
+	for _, head := range heads {
+		stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
+			cmd.MustConfigure(exec.Command("git", "merge", "--no-ff", "--no-edit", head.remoteBranch()), c.inWorktree(dir)),
+		}).Run()
+		log.PrintLinesPrefixed(branch, stdout)
+		log.PrintLinesPrefixed(branch, stderr)
+		if err != nil {
+			stdout, stderr, _ := cmd.Pipeline([]*exec.Cmd{
+				cmd.MustConfigure(exec.Command("git", "merge", "--abort"), c.inWorktree(dir)),
+			}).Run()
+			log.PrintLinesPrefixed(branch, stdout)
+			log.PrintLinesPrefixed(branch, stderr)
+			return "", &MergeConflictError{Branch: head.Ref, Head: head}
+		}
+	}
+
+	stdout, stderr, err = cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", "push", "-f", "origin", fmt.Sprintf("HEAD:refs/heads/%s", branch)), c.inWorktree(dir)),
+		cmd.MustConfigure(exec.Command("git", "rev-parse", "HEAD"), c.inWorktree(dir)),
+	}).Run()
+	log.PrintLinesPrefixed(branch, stdout)
+	log.PrintLinesPrefixed(branch, stderr)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	return lines[len(lines)-1], nil
+}
+
+// FastForward moves mainline to the given sha. It fails if mainline moved
+// in the meantime and can not be fast-forwarded
+func (c *Cache) FastForward(sha string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", "fetch", "origin"), c.inCacheDirectory()),
+		cmd.MustConfigure(exec.Command("git", "push", "origin", fmt.Sprintf("%s:refs/heads/%s", sha, c.mainline)), c.inCacheDirectory()),
+	}).Run()
+	log.PrintLinesPrefixed(c.mainline, stdout)
+	log.PrintLinesPrefixed(c.mainline, stderr)
+	return err
+}
@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func revParse(dir, ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", ref)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func TestCache_Stage(t *testing.T) {
+	tmp, err := setupTestScenario()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tmp)
+
+	cache, err := prepareTestCache(tmp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cache.Close()
+
+	t.Run("pushes merged branches", func(t *testing.T) {
+		sha, err := cache.Stage("staging", []Head{{Ref: "needs-rebase"}, {Ref: "up-2-date"}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		staged, err := revParse(tmp, "staging")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if staged != sha {
+			t.Fatalf("Expected staging at %q, but got %q", sha, staged)
+		}
+	})
+
+	t.Run("reports conflicting branches", func(t *testing.T) {
+		_, err := cache.Stage("staging", []Head{{Ref: "needs-rebase"}, {Ref: "conflict"}})
+		conflict, ok := err.(*MergeConflictError)
+		if !ok {
+			t.Fatalf("Expected merge conflict, but got %v", err)
+		}
+		if conflict.Branch != "conflict" {
+			t.Fatalf("Expected conflict on %q, but got %q", "conflict", conflict.Branch)
+		}
+	})
+
+	t.Run("fast-forwards mainline", func(t *testing.T) {
+		sha, err := cache.Stage("staging", []Head{{Ref: "needs-rebase"}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// pushing to the checked out branch of a non-bare repository is refused
+		checkout := exec.Command("git", "checkout", "up-2-date")
+		checkout.Dir = tmp
+		if out, err := checkout.CombinedOutput(); err != nil {
+			t.Fatal(string(out))
+		}
+
+		if err := cache.FastForward(sha); err != nil {
+			t.Fatal(err.Error())
+		}
+		mainline, err := revParse(tmp, "master")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if mainline != sha {
+			t.Fatalf("Expected master at %q, but got %q", sha, mainline)
+		}
+	})
+}
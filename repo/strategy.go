@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/nicolai86/github-rebase-bot/repo/internal/cmd"
+	"github.com/nicolai86/github-rebase-bot/repo/internal/log"
+)
+
+// UpdateStrategy controls how branches are brought up to date with mainline
+type UpdateStrategy string
+
+const (
+	// UpdateRebase rebases branches onto mainline and force pushes them
+	UpdateRebase UpdateStrategy = "rebase"
+	// UpdateMerge merges mainline into branches and pushes them without rewriting history
+	UpdateMerge UpdateStrategy = "merge"
+	// UpdateAPI merges mainline into branches remotely using a BranchUpdater
+	UpdateAPI UpdateStrategy = "api"
+)
+
+// ValidUpdateStrategy reports if s is a known update strategy
+func ValidUpdateStrategy(s string) bool {
+	switch UpdateStrategy(s) {
+	case UpdateRebase, UpdateMerge, UpdateAPI:
+		return true
+	}
+	return false
+}
+
+// BranchUpdater merges mainline into a branch on the remote, e.g. using github's update branch API
+type BranchUpdater interface {
+	UpdateBranch(Head) error
+}
+
+// UseStrategy selects how workers update their branch, starting with their next rebase.
+// updater is only used by UpdateAPI
+func (c *Cache) UseStrategy(strategy UpdateStrategy, updater BranchUpdater) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.strategy = strategy
+	c.updater = updater
+}
+
+// contains reports if a branch contains the latest mainline already
+func (w *Worker) contains(dir string) (bool, error) {
+	_, stderr, err := cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", "merge-base", "--is-ancestor", fmt.Sprintf("origin/%s", w.cache.Mainline()), "HEAD"), w.cache.inWorktree(dir)),
+	}).Run()
+	if err == nil {
+		return true, nil
+	}
+	// merge-base exits with 1 if mainline is not an ancestor, and with other codes on errors
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	log.PrintLinesPrefixed(w.branch, stderr)
+	return false, err
+}
+
+// merge merges mainline into the branch. It reports true if no merge was necessary
+func (w *Worker) merge(dir string) (bool, error) {
+	if ok, err := w.contains(dir); err != nil || ok {
+		return ok, err
+	}
+
+	mainline := fmt.Sprintf("origin/%s", w.cache.Mainline())
+	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", "merge", "--no-edit", "-m", fmt.Sprintf("Merge %s into %s", w.cache.Mainline(), w.head.Ref), mainline), w.cache.inWorktree(dir)),
+	}).Run()
+	log.PrintLinesPrefixed(w.branch, stdout)
+	log.PrintLinesPrefixed(w.branch, stderr)
+	if err != nil {
+		if conflict := w.conflict(dir, ""); conflict != nil {
+			err = conflict
+		}
+		stdout, stderr, _ := cmd.Pipeline([]*exec.Cmd{
+			cmd.MustConfigure(exec.Command("git", "merge", "--abort"), w.cache.inWorktree(dir)),
+		}).Run()
+		log.PrintLinesPrefixed(w.branch, stdout)
+		log.PrintLinesPrefixed(w.branch, stderr)
+		return false, err
+	}
+	return false, nil
+}
+
+// updateRemote asks the updater to merge mainline into the branch. It reports
+// true if no update was necessary
+func (w *Worker) updateRemote(dir string) (bool, error) {
+	if ok, err := w.contains(dir); err != nil || ok {
+		return ok, err
+	}
+	if w.updater == nil {
+		return false, fmt.Errorf("no branch updater configured for %s", w.branch)
+	}
+	return false, w.updater.UpdateBranch(w.head)
+}
@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gitVersion is the major and minor version of git
+type gitVersion struct {
+	major, minor int
+}
+
+func (v gitVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// atLeast reports if v is the given version or newer
+func (v gitVersion) atLeast(min gitVersion) bool {
+	return v.major > min.major || v.major == min.major && v.minor >= min.minor
+}
+
+// parseGitVersion parses the output of git version, e.g. "git version 2.34.1"
+func parseGitVersion(s string) (gitVersion, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return gitVersion{}, fmt.Errorf("unexpected git version %q", strings.TrimSpace(s))
+	}
+	parts := strings.SplitN(fields[2], ".", 3)
+	if len(parts) < 2 {
+		return gitVersion{}, fmt.Errorf("unexpected git version %q", fields[2])
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return gitVersion{}, fmt.Errorf("unexpected git version %q", fields[2])
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return gitVersion{}, fmt.Errorf("unexpected git version %q", fields[2])
+	}
+	return gitVersion{major: major, minor: minor}, nil
+}
+
+var installedGit struct {
+	once    sync.Once
+	version gitVersion
+	err     error
+}
+
+// installedGitVersion returns the version of the git binary all commands run with
+func installedGitVersion() (gitVersion, error) {
+	installedGit.once.Do(func() {
+		out, err := exec.Command("git", "version").Output()
+		if err != nil {
+			installedGit.err = fmt.Errorf("looking up git version failed: %v", err)
+			return
+		}
+		installedGit.version, installedGit.err = parseGitVersion(string(out))
+	})
+	return installedGit.version, installedGit.err
+}
+
+// gitRequirement is the oldest git supporting a feature
+type gitRequirement struct {
+	feature string
+	version gitVersion
+}
+
+// checkGit returns an error naming the first requirement v doesn't meet
+func checkGit(v gitVersion, requirements []gitRequirement) error {
+	for _, req := range requirements {
+		if !v.atLeast(req.version) {
+			return fmt.Errorf("%s requires git %s or newer, but git %s is installed", req.feature, req.version, v)
+		}
+	}
+	return nil
+}
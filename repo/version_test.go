@@ -0,0 +1,56 @@
+package repo
+
+import "testing"
+
+func TestParseGitVersion(t *testing.T) {
+	for input, expected := range map[string]gitVersion{
+		"git version 2.13.7\n":               {2, 13},
+		"git version 2.34.1":                 {2, 34},
+		"git version 2.39.3 (Apple Git-145)": {2, 39},
+		"git version 2.41.0.windows.1":       {2, 41},
+		"git version 3.0":                    {3, 0},
+	} {
+		actual, err := parseGitVersion(input)
+		if err != nil {
+			t.Errorf("Expected %q to parse, but got %v", input, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("Expected %q to be %v, but got %v", input, expected, actual)
+		}
+	}
+
+	for _, input := range []string{"", "git version", "git version two", "hub version 2.34.1"} {
+		if _, err := parseGitVersion(input); err == nil {
+			t.Errorf("Expected %q to fail", input)
+		}
+	}
+}
+
+func TestCheckGit(t *testing.T) {
+	id := Identity{SigningKey: "key", SigningFormat: SigningSSH}
+
+	if err := checkGit(gitVersion{2, 13}, id.gitRequirements()); err == nil {
+		t.Error("Expected ssh signing to require a newer git")
+	}
+	for _, v := range []gitVersion{{2, 34}, {2, 39}, {3, 0}} {
+		if err := checkGit(v, id.gitRequirements()); err != nil {
+			t.Errorf("Expected git %s to support ssh signing, but got %v", v, err)
+		}
+	}
+	for provenance, oldest := range map[Provenance]gitVersion{
+		ProvenanceCommitter: {2, 29},
+		ProvenanceTrailer:   {2, 32},
+	} {
+		id := Identity{Provenance: provenance}
+		if err := checkGit(gitVersion{oldest.major, oldest.minor - 1}, id.gitRequirements()); err == nil {
+			t.Errorf("Expected %s provenance to require git %s", provenance, oldest)
+		}
+		if err := checkGit(oldest, id.gitRequirements()); err != nil {
+			t.Errorf("Expected git %s to support %s provenance, but got %v", oldest, provenance, err)
+		}
+	}
+	if reqs := (Identity{SigningFormat: SigningSSH}).gitRequirements(); len(reqs) != 0 {
+		t.Errorf("Expected identities without signing key to have no requirements, but got %v", reqs)
+	}
+}
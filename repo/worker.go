@@ -1,10 +1,10 @@
 package repo
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"os/exec"
 	"path"
 	"strings"
@@ -19,6 +19,9 @@ type GitCache interface {
 	Mainline() string
 	Cleanup(GitWorktree) error
 	inCacheDirectory() func(*exec.Cmd)
+	inWorktree(string) func(*exec.Cmd)
+	environ() []string
+	updateSettings() (UpdateStrategy, BranchUpdater, Provenance)
 }
 
 type GitWorker interface {
@@ -26,7 +29,7 @@ type GitWorker interface {
 	update(string) error
 	rebase(string) (bool, error)
 	push(string) error
-	Branch() string
+	GitWorktree
 }
 
 type Enqueuer interface {
@@ -37,14 +40,28 @@ type Enqueuer interface {
 type Worker struct {
 	cache  GitCache
 	branch string
+	head   Head
 	queue  chan chan Signal
 	stop   context.CancelFunc
+
+	// strategy, updater and provenance are read from the cache for every rebase
+	// and used until the branch is pushed
+	strategy   UpdateStrategy
+	updater    BranchUpdater
+	provenance Provenance
+	// sha is the sha of the branch the worker updated from. Pushes are rejected
+	// if the branch moved away from it in the meantime
+	sha string
 }
 
 func (w *Worker) Branch() string {
 	return w.branch
 }
 
+func (w *Worker) Key() string {
+	return w.head.Key()
+}
+
 func (w *Worker) Enqueue(c chan Signal) {
 	w.queue <- c
 }
@@ -55,15 +72,107 @@ func inDir(dir string) func(*exec.Cmd) {
 	}
 }
 
+// RebaseConflictError is returned when a branch can not be updated with mainline
+// because of conflicts
+type RebaseConflictError struct {
+	Branch   string
+	Mainline string
+	// Commit and Subject identify the commit which failed to apply while rebasing.
+	// They are empty if merging mainline into the branch failed
+	Commit  string
+	Subject string
+	// Paths lists the conflicting files
+	Paths []string
+}
+
+func (e *RebaseConflictError) Error() string {
+	if e.Commit == "" {
+		return fmt.Sprintf("%s does not merge cleanly with %s, conflicts in %s", e.Branch, e.Mainline, strings.Join(e.Paths, ", "))
+	}
+	commit := e.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	return fmt.Sprintf("commit %s %q of %s does not apply onto %s, conflicts in %s", commit, e.Subject, e.Branch, e.Mainline, strings.Join(e.Paths, ", "))
+}
+
+// StaleBranchError is returned when a branch changed on the remote while it was
+// updated, e.g. because its author pushed new commits
+type StaleBranchError struct {
+	Branch string
+	// Head is the sha the branch was updated from
+	Head string
+}
+
+func (e *StaleBranchError) Error() string {
+	return fmt.Sprintf("%s moved away from %s while it was updated", e.Branch, e.Head)
+}
+
+// conflict inspects a stopped rebase or merge. head names the commit which failed
+// to apply, if any. It returns nil if there are no conflicting paths
+func (w *Worker) conflict(dir, head string) *RebaseConflictError {
+	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", "diff", "--name-only", "--diff-filter=U"), w.cache.inWorktree(dir)),
+	}).Run()
+	log.PrintLinesPrefixed(w.branch, stderr)
+	if err != nil {
+		return nil
+	}
+	paths := []string{}
+	for _, line := range strings.Split(stdout, "\n")[1:] {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	conflict := &RebaseConflictError{Branch: w.head.Ref, Mainline: w.cache.Mainline(), Paths: paths}
+	if head == "" {
+		return conflict
+	}
+	stdout, stderr, err = cmd.Pipeline([]*exec.Cmd{
+		cmd.MustConfigure(exec.Command("git", "log", "-1", "--format=%H%n%s", head), w.cache.inWorktree(dir)),
+	}).Run()
+	log.PrintLinesPrefixed(w.branch, stderr)
+	if lines := strings.Split(stdout, "\n"); err == nil && len(lines) >= 3 {
+		conflict.Commit, conflict.Subject = lines[1], lines[2]
+	}
+	return conflict
+}
+
+// rebase brings the branch up to date with mainline using the current update
+// strategy of the cache. It reports true if the branch was up to date already
 func (w *Worker) rebase(dir string) (bool, error) {
+	w.strategy, w.updater, w.provenance = w.cache.updateSettings()
+	switch w.strategy {
+	case UpdateMerge:
+		return w.merge(dir)
+	case UpdateAPI:
+		return w.updateRemote(dir)
+	}
+
+	args := []string{"rebase"}
+	if w.provenance != ProvenanceNone {
+		// recording the provenance rewrites commits even if the branch is up to date already
+		if ok, err := w.contains(dir); err != nil || ok {
+			return ok, err
+		}
+		args = append(args, w.provenance.rebaseArgs()...)
+	}
+	args = append(args, fmt.Sprintf("origin/%s", w.cache.Mainline()))
 	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
-		cmd.MustConfigure(exec.Command("git", "rebase", fmt.Sprintf("origin/%s", w.cache.Mainline())), inDir(dir)),
+		cmd.MustConfigure(exec.Command("git", args...), w.cache.inWorktree(dir)),
 	}).Run()
 	log.PrintLinesPrefixed(w.branch, stdout)
 	log.PrintLinesPrefixed(w.branch, stderr)
 	if err != nil {
+		if conflict := w.conflict(dir, "REBASE_HEAD"); conflict != nil {
+			err = conflict
+		}
 		stdout, stderr, _ := cmd.Pipeline([]*exec.Cmd{
-			cmd.MustConfigure(exec.Command("git", "rebase", "--abort"), inDir(dir)),
+			cmd.MustConfigure(exec.Command("git", "rebase", "--abort"), w.cache.inWorktree(dir)),
 		}).Run()
 		log.PrintLinesPrefixed(w.branch, stdout)
 		log.PrintLinesPrefixed(w.branch, stderr)
@@ -72,11 +181,32 @@ func (w *Worker) rebase(dir string) (bool, error) {
 	return strings.Contains(stdout, "is up to date"), nil
 }
 
+// push publishes the updated branch. Only rebased branches are force pushed,
+// as long as the remote branch did not move. Branches updated remotely are not pushed at all
 func (w *Worker) push(dir string) error {
-	cmd := exec.Command("git", "push", "--set-upstream", "origin", w.branch, "-f")
+	// branches of forks are pushed to the fork directly
+	args := []string{"push", w.head.pushRemote(), fmt.Sprintf("HEAD:refs/heads/%s", w.head.Ref)}
+	switch w.strategy {
+	case UpdateMerge:
+	case UpdateAPI:
+		return nil
+	default:
+		args = append(args, fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", w.head.Ref, w.sha))
+	}
+	cmd := exec.Command("git", args...)
 	cmd.Dir = dir
-	cmd.Env = os.Environ()
-	return cmd.Run()
+	cmd.Env = w.cache.environ()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		log.PrintLinesPrefixed(w.branch, stderr.String())
+		// both a failing lease and a non-fast-forward push are reported as rejected
+		if strings.Contains(stderr.String(), "[rejected]") {
+			return &StaleBranchError{Branch: w.head.Ref, Head: w.sha}
+		}
+		return err
+	}
+	return nil
 }
 
 func (w *Worker) prepare() (string, error) {
@@ -90,8 +220,8 @@ func (w *Worker) prepare() (string, error) {
 	}
 
 	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
-		cmd.MustConfigure(exec.Command("git", "worktree", "add", dir, fmt.Sprintf("remotes/origin/%s", w.branch)), w.cache.inCacheDirectory()),
-		cmd.MustConfigure(exec.Command("git", "checkout", w.branch), inDir(dir)),
+		cmd.MustConfigure(exec.Command("git", "fetch", "origin", w.head.fetchSpec()), w.cache.inCacheDirectory()),
+		cmd.MustConfigure(exec.Command("git", "worktree", "add", "-B", w.branch, dir, w.head.remoteBranch()), w.cache.inCacheDirectory()),
 	}).Run()
 	log.PrintLinesPrefixed(w.branch, stdout)
 	log.PrintLinesPrefixed(w.branch, stderr)
@@ -100,11 +230,17 @@ func (w *Worker) prepare() (string, error) {
 
 func (w *Worker) update(dir string) error {
 	stdout, stderr, err := cmd.Pipeline([]*exec.Cmd{
-		cmd.MustConfigure(exec.Command("git", "fetch", "origin", w.branch), inDir(dir)),
-		cmd.MustConfigure(exec.Command("git", "reset", "--hard", fmt.Sprintf("origin/%s", w.branch)), inDir(dir)),
-		cmd.MustConfigure(exec.Command("git", "clean", "-f", "-d", "-x"), inDir(dir)),
+		cmd.MustConfigure(exec.Command("git", "fetch", "origin", w.head.fetchSpec()), w.cache.inWorktree(dir)),
+		cmd.MustConfigure(exec.Command("git", "reset", "--hard", w.head.remoteBranch()), w.cache.inWorktree(dir)),
+		cmd.MustConfigure(exec.Command("git", "clean", "-f", "-d", "-x"), w.cache.inWorktree(dir)),
+		cmd.MustConfigure(exec.Command("git", "rev-parse", "HEAD"), w.cache.inWorktree(dir)),
 	}).Run()
 	log.PrintLinesPrefixed(w.branch, stdout)
 	log.PrintLinesPrefixed(w.branch, stderr)
-	return err
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	w.sha = strings.TrimSpace(lines[len(lines)-1])
+	return nil
 }
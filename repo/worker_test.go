@@ -3,6 +3,8 @@ package repo
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"strings"
 	"testing"
@@ -43,7 +45,7 @@ func TestWorker_rebase(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	cache, err := Prepare(tmp, "master")
+	cache, err := prepareTestCache(tmp)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -61,8 +63,16 @@ func TestWorker_rebase(t *testing.T) {
 		if err != nil {
 			t.Fatal(err.Error())
 		}
-		if _, err := w.rebase(dir); err == nil {
-			t.Fatalf("Expected rebase to error due to conflict, but didn't")
+		_, err = w.rebase(dir)
+		conflict, ok := err.(*RebaseConflictError)
+		if !ok {
+			t.Fatalf("Expected rebase to error due to conflict, but got %v", err)
+		}
+		if len(conflict.Paths) != 1 || conflict.Paths[0] != "README.md" {
+			t.Fatalf("Expected conflict in README.md, but got %v", conflict.Paths)
+		}
+		if len(conflict.Commit) != 40 || conflict.Subject == "" {
+			t.Fatalf("Expected conflicting commit, but got %q %q", conflict.Commit, conflict.Subject)
 		}
 	})
 
@@ -109,7 +119,7 @@ func TestWorker_prepare(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	cache, err := Prepare(tmp, "master")
+	cache, err := prepareTestCache(tmp)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -136,7 +146,7 @@ func TestWorker_update(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	cache, err := Prepare(tmp, "master")
+	cache, err := prepareTestCache(tmp)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -207,3 +217,219 @@ func TestWorker_update(t *testing.T) {
 		}
 	})
 }
+
+type fakeBranchUpdater struct {
+	updated []string
+}
+
+func (f *fakeBranchUpdater) UpdateBranch(head Head) error {
+	f.updated = append(f.updated, head.Ref)
+	return nil
+}
+
+func TestWorker_strategies(t *testing.T) {
+	tmp, err := setupTestScenario()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cache, err := prepareTestCache(tmp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	updater := &fakeBranchUpdater{}
+
+	worker := func(t *testing.T, strategy UpdateStrategy, branch string) (*Worker, string) {
+		cache.UseStrategy(strategy, updater)
+		v, err := cache.Worker(branch)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w := v.(*Worker)
+		dir, err := w.prepare()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := w.update(dir); err != nil {
+			t.Fatal(err.Error())
+		}
+		return w, dir
+	}
+
+	t.Run("merges mainline", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("needs-rebase"))
+		w, dir := worker(t, UpdateMerge, "needs-rebase")
+		before, err := getSHA(dir)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if ok, err := w.rebase(dir); err != nil || ok {
+			t.Fatalf("Expected merge to be necessary, but got %v, %v", ok, err)
+		}
+		// the previous head is kept, so history is not rewritten
+		if ok, err := w.contains(dir); err != nil || !ok {
+			t.Fatalf("Expected branch to contain mainline, but got %v, %v", ok, err)
+		}
+		cmd := exec.Command("git", "merge-base", "--is-ancestor", before, "HEAD")
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Expected %s to be kept: %v", before, err)
+		}
+	})
+
+	t.Run("detects merge conflicts", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("conflict"))
+		w, dir := worker(t, UpdateMerge, "conflict")
+		_, err := w.rebase(dir)
+		conflict, ok := err.(*RebaseConflictError)
+		if !ok {
+			t.Fatalf("Expected merge to error due to conflict, but got %v", err)
+		}
+		if len(conflict.Paths) != 1 || conflict.Paths[0] != "README.md" || conflict.Commit != "" {
+			t.Fatalf("Unexpected conflict %#v", conflict)
+		}
+	})
+
+	t.Run("updates remotely", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("up-2-date"))
+		defer cache.Cleanup(StringGitWorktree("needs-rebase"))
+		w, dir := worker(t, UpdateAPI, "up-2-date")
+		if ok, err := w.rebase(dir); err != nil || !ok {
+			t.Fatalf("Expected branch to be up to date, but got %v, %v", ok, err)
+		}
+		w, dir = worker(t, UpdateAPI, "needs-rebase")
+		if ok, err := w.rebase(dir); err != nil || ok {
+			t.Fatalf("Expected branch to be updated, but got %v, %v", ok, err)
+		}
+		if len(updater.updated) != 1 || updater.updated[0] != "needs-rebase" {
+			t.Fatalf("Expected needs-rebase to be updated remotely, but got %v", updater.updated)
+		}
+	})
+
+	t.Run("applies reloaded strategies to existing workers", func(t *testing.T) {
+		defer cache.Cleanup(StringGitWorktree("needs-rebase"))
+		updater.updated = nil
+		w, dir := worker(t, UpdateRebase, "needs-rebase")
+		cache.UseStrategy(UpdateAPI, updater)
+		if ok, err := w.rebase(dir); err != nil || ok {
+			t.Fatalf("Expected branch to be updated, but got %v, %v", ok, err)
+		}
+		if len(updater.updated) != 1 || updater.updated[0] != "needs-rebase" {
+			t.Fatalf("Expected needs-rebase to be updated remotely, but got %v", updater.updated)
+		}
+	})
+}
+
+func TestWorker_push(t *testing.T) {
+	tmp, err := setupTestScenario()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cache, err := prepareTestCache(tmp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	t.Run("does not overwrite new commits", func(t *testing.T) {
+		branch := "needs-rebase"
+
+		defer cache.Cleanup(StringGitWorktree(branch))
+		v, err := cache.Worker(branch)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w := v.(*Worker)
+		dir, err := w.prepare()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := w.update(dir); err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := w.rebase(dir); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// the author pushes while the branch is rebased
+		author, err := ioutil.TempDir("", "author")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer os.RemoveAll(author)
+		for _, args := range [][]string{
+			{"git", "clone", "--branch", branch, tmp, author},
+			{"git", "-c", "user.name=author", "-c", "user.email=author@example.com", "commit", "--allow-empty", "-m", "fix"},
+			{"git", "push", "origin", branch},
+		} {
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Dir = author
+			if err := cmd.Run(); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		latest, err := revParse(tmp, branch)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		err = w.push(dir)
+		if _, ok := err.(*StaleBranchError); !ok {
+			t.Fatalf("Expected push to be rejected, but got %v", err)
+		}
+		if sha, err := revParse(tmp, branch); err != nil || sha != latest {
+			t.Fatalf("Expected %s to be kept, but got %s", latest, sha)
+		}
+	})
+
+	t.Run("pushes forks to their repository", func(t *testing.T) {
+		branch := "needs-rebase"
+
+		// the fork's branch is exposed as a pull request ref by the base repository
+		fork, err := ioutil.TempDir("", "fork")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer os.RemoveAll(fork)
+		for _, args := range [][]string{
+			{"git", "-C", tmp, "update-ref", "refs/pull/1/head", fmt.Sprintf("refs/heads/%s", branch)},
+			{"git", "clone", "--bare", tmp, fork},
+		} {
+			if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		before, err := revParse(tmp, branch)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		head := Head{Number: 1, Ref: branch, URL: fork}
+		defer cache.Cleanup(head)
+		v, err := cache.HeadWorker(head)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		w := v.(*Worker)
+		dir, err := w.prepare()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := w.update(dir); err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := w.rebase(dir); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := w.push(dir); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := exec.Command("git", "-C", fork, "merge-base", "--is-ancestor", "master", branch).Run(); err != nil {
+			t.Fatalf("Expected the fork to be rebased onto master, but got %v", err)
+		}
+		if sha, err := revParse(tmp, branch); err != nil || sha != before {
+			t.Fatalf("Expected %s of the base repository to be kept, but got %s", before, sha)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
+	"github.com/nicolai86/github-rebase-bot/processors"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+// statusContext is the context of the commit status reported by the bot.
+// It's ignored when evaluating the statuses of a pull request
+const statusContext = processors.StatusContext
+
+// stickyMarker identifies the comment the bot keeps updating on a pull request
+const stickyMarker = "<!-- rebase-bot -->"
+
+// StatusCreator reports commit statuses
+type StatusCreator interface {
+	CreateStatus(context.Context, string, string, string, *github.RepoStatus) (*github.RepoStatus, *github.Response, error)
+}
+
+// CommentClient lists, creates and edits issue comments
+type CommentClient interface {
+	ListComments(context.Context, string, string, int, *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error)
+	CreateComment(context.Context, string, string, int, *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	EditComment(context.Context, string, string, int, *github.IssueComment) (*github.IssueComment, *github.Response, error)
+}
+
+// reporter explains what the bot is doing with a pull request via a commit
+// status on its head and a single sticky comment describing why it's blocked.
+// A nil reporter reports nothing
+type reporter struct {
+	owner    string
+	name     string
+	mainline string
+	// statuses and comments may be nil to disable the respective feedback
+	statuses StatusCreator
+	comments CommentClient
+
+	mu sync.Mutex
+	// reported contains the head sha and last status reported per pull request
+	reported map[int]string
+	// blocked contains the reason of the sticky comment per pull request
+	blocked    map[int]string
+	commentIDs map[int]int
+}
+
+func newReporter(owner, name, mainline string, statuses StatusCreator, comments CommentClient) *reporter {
+	return &reporter{
+		owner:      owner,
+		name:       name,
+		mainline:   mainline,
+		statuses:   statuses,
+		comments:   comments,
+		reported:   map[int]string{},
+		blocked:    map[int]string{},
+		commentIDs: map[int]int{},
+	}
+}
+
+// status reports the state of the head of a pull request unless it's reported already
+func (r *reporter) status(pr *github.PullRequest, state, description string) {
+	if r.statuses == nil || pr.Head.GetSHA() == "" {
+		return
+	}
+	// descriptions are limited to 140 characters
+	if len(description) > 140 {
+		description = description[:137] + "..."
+	}
+
+	r.mu.Lock()
+	key := fmt.Sprintf("%s:%s:%s", pr.Head.GetSHA(), state, description)
+	if r.reported[pr.GetNumber()] == key {
+		r.mu.Unlock()
+		return
+	}
+	r.reported[pr.GetNumber()] = key
+	r.mu.Unlock()
+
+	if _, _, err := r.statuses.CreateStatus(context.Background(), r.owner, r.name, pr.Head.GetSHA(), &github.RepoStatus{
+		State:       &state,
+		Description: &description,
+		Context:     github.String(statusContext),
+	}); err != nil {
+		log.Printf("%s/%s: pr %d failed to report status: %v\n", r.owner, r.name, pr.GetNumber(), err)
+	}
+}
+
+// Progress reports a pull request as pending. A sticky comment explaining
+// why it was blocked is resolved
+func (r *reporter) Progress(pr *github.PullRequest, description string) {
+	if r == nil {
+		return
+	}
+	r.status(pr, "pending", description)
+	r.resolve(pr, fmt.Sprintf("rebase-bot is working on this pull request again: %s.", description))
+}
+
+// Block reports a pull request as blocked and explains the reason in the sticky comment
+func (r *reporter) Block(pr *github.PullRequest, reason string) {
+	if r == nil {
+		return
+	}
+	r.status(pr, "failure", reason)
+
+	r.mu.Lock()
+	if r.blocked[pr.GetNumber()] == reason {
+		r.mu.Unlock()
+		return
+	}
+	r.blocked[pr.GetNumber()] = reason
+	r.mu.Unlock()
+
+	r.comment(pr, fmt.Sprintf(":no_entry: rebase-bot can't merge this pull request: **%s**.\n\nIt's picked up again automatically once this is resolved.", reason))
+}
+
+// Merged reports a pull request as merged
+func (r *reporter) Merged(pr *github.PullRequest) {
+	if r == nil {
+		return
+	}
+	r.status(pr, "success", fmt.Sprintf("merged into %s", r.mainline))
+	r.resolve(pr, fmt.Sprintf("rebase-bot merged this pull request into %s.", r.mainline))
+	r.Forget(pr)
+}
+
+// Forget drops everything reported about a closed or merged pull request
+func (r *reporter) Forget(pr *github.PullRequest) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.reported, pr.GetNumber())
+	delete(r.blocked, pr.GetNumber())
+	delete(r.commentIDs, pr.GetNumber())
+	r.mu.Unlock()
+}
+
+// resolve updates the sticky comment of a blocked pull request
+func (r *reporter) resolve(pr *github.PullRequest, message string) {
+	r.mu.Lock()
+	reason, ok := r.blocked[pr.GetNumber()]
+	r.blocked[pr.GetNumber()] = ""
+	r.mu.Unlock()
+	if !ok || reason == "" {
+		return
+	}
+	r.comment(pr, ":white_check_mark: "+message)
+}
+
+// comment creates or updates the sticky comment of a pull request
+func (r *reporter) comment(pr *github.PullRequest, message string) {
+	if r.comments == nil {
+		return
+	}
+	body := fmt.Sprintf("%s\n%s", stickyMarker, message)
+
+	id, err := r.commentID(pr)
+	if err != nil {
+		log.Printf("%s/%s: pr %d failed to lookup comments: %v\n", r.owner, r.name, pr.GetNumber(), err)
+		return
+	}
+	if id != 0 {
+		if _, _, err := r.comments.EditComment(context.Background(), r.owner, r.name, id, &github.IssueComment{Body: &body}); err != nil {
+			log.Printf("%s/%s: pr %d failed to edit comment: %v\n", r.owner, r.name, pr.GetNumber(), err)
+		}
+		return
+	}
+
+	c, _, err := r.comments.CreateComment(context.Background(), r.owner, r.name, pr.GetNumber(), &github.IssueComment{Body: &body})
+	if err != nil {
+		log.Printf("%s/%s: pr %d failed to comment: %v\n", r.owner, r.name, pr.GetNumber(), err)
+		return
+	}
+	r.mu.Lock()
+	r.commentIDs[pr.GetNumber()] = c.GetID()
+	r.mu.Unlock()
+}
+
+// commentID finds the sticky comment of a pull request. It returns 0 if there is none
+func (r *reporter) commentID(pr *github.PullRequest) (int, error) {
+	r.mu.Lock()
+	id, ok := r.commentIDs[pr.GetNumber()]
+	r.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	opt := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := r.comments.ListComments(context.Background(), r.owner, r.name, pr.GetNumber(), opt)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range comments {
+			if strings.HasPrefix(c.GetBody(), stickyMarker) {
+				id = c.GetID()
+			}
+		}
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	if id != 0 {
+		r.mu.Lock()
+		r.commentIDs[pr.GetNumber()] = id
+		r.mu.Unlock()
+	}
+	return id, nil
+}
+
+// rebaseFailure explains why a pull request could not be rebased
+func rebaseFailure(mainline string, err error) string {
+	if conflict, ok := err.(*repo.RebaseConflictError); ok {
+		return conflict.Error()
+	}
+	return fmt.Sprintf("rebasing onto %s failed: %v", mainline, err)
+}
+
+// stageReporter records stages in the journal and reports them on the pull request
+type stageReporter struct {
+	journal  processors.StageRecorder
+	reporter *reporter
+}
+
+// Record reports the stage of a pull request and records it in the journal, if any
+func (s stageReporter) Record(pr *github.PullRequest, stage journal.Stage) error {
+	switch stage {
+	case journal.StageVerified:
+		s.reporter.Progress(pr, "queued")
+	case journal.StageRebasing:
+		s.reporter.Progress(pr, fmt.Sprintf("rebasing onto %s", s.reporter.mainline))
+	case journal.StageMerging:
+		s.reporter.Progress(pr, "merging")
+	}
+	// pushed branches have a new head, which is reported once it's verified again
+
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Record(pr, stage)
+}
+
+// Remove removes a pull request from the journal, if any
+func (s stageReporter) Remove(pr *github.PullRequest) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Remove(pr)
+}
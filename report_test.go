@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+type fakeStatusCreator struct {
+	statuses []github.RepoStatus
+}
+
+func (f *fakeStatusCreator) CreateStatus(_ context.Context, _, _, _ string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error) {
+	f.statuses = append(f.statuses, *status)
+	return status, nil, nil
+}
+
+type fakeCommentClient struct {
+	comments []*github.IssueComment
+	created  int
+	edited   int
+}
+
+func (f *fakeCommentClient) ListComments(_ context.Context, _, _ string, _ int, _ *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	return f.comments, nil, nil
+}
+
+func (f *fakeCommentClient) CreateComment(_ context.Context, _, _ string, _ int, c *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	f.created++
+	c.ID = intVal(len(f.comments) + 1)
+	f.comments = append(f.comments, c)
+	return c, nil, nil
+}
+
+func (f *fakeCommentClient) EditComment(_ context.Context, _, _ string, id int, c *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	f.edited++
+	for _, existing := range f.comments {
+		if existing.GetID() == id {
+			existing.Body = c.Body
+		}
+	}
+	return c, nil, nil
+}
+
+func reportedPullRequest(sha string) *github.PullRequest {
+	return &github.PullRequest{
+		Number: intVal(1),
+		Head:   &github.PullRequestBranch{SHA: stringVal(sha)},
+	}
+}
+
+func TestReporter(t *testing.T) {
+	t.Run("reports statuses once per head", func(t *testing.T) {
+		statuses := &fakeStatusCreator{}
+		rep := newReporter("test", "test", "master", statuses, nil)
+
+		rep.Progress(reportedPullRequest("a"), "queued")
+		rep.Progress(reportedPullRequest("a"), "queued")
+		rep.Progress(reportedPullRequest("a"), "merging")
+		rep.Progress(reportedPullRequest("b"), "merging")
+
+		if len(statuses.statuses) != 3 {
+			t.Fatalf("Expected 3 statuses, but got %d", len(statuses.statuses))
+		}
+		s := statuses.statuses[2]
+		if s.GetContext() != statusContext || s.GetState() != "pending" || s.GetDescription() != "merging" {
+			t.Fatalf("Unexpected status %v", s)
+		}
+	})
+
+	t.Run("keeps a single sticky comment", func(t *testing.T) {
+		comments := &fakeCommentClient{comments: []*github.IssueComment{
+			{ID: intVal(1), Body: stringVal("LGTM")},
+		}}
+		rep := newReporter("test", "test", "master", nil, comments)
+		pr := reportedPullRequest("a")
+
+		rep.Block(pr, "is not mergeable")
+		rep.Block(pr, "is not mergeable")
+		if comments.created != 1 || comments.edited != 0 {
+			t.Fatalf("Expected a single comment, but got %d created and %d edited", comments.created, comments.edited)
+		}
+
+		rep.Block(pr, "status is failure")
+		rep.Progress(pr, "queued")
+		rep.Progress(pr, "merging")
+		if comments.created != 1 || comments.edited != 2 {
+			t.Fatalf("Expected the comment to be edited twice, but got %d created and %d edited", comments.created, comments.edited)
+		}
+		body := comments.comments[1].GetBody()
+		if !strings.HasPrefix(body, stickyMarker) || !strings.Contains(body, "working on this pull request again") {
+			t.Fatalf("Unexpected comment %q", body)
+		}
+	})
+
+	t.Run("finds existing sticky comments", func(t *testing.T) {
+		comments := &fakeCommentClient{comments: []*github.IssueComment{
+			{ID: intVal(7), Body: stringVal(stickyMarker + "\nblocked")},
+		}}
+		rep := newReporter("test", "test", "master", nil, comments)
+
+		rep.Block(reportedPullRequest("a"), "is not mergeable")
+		if comments.created != 0 || comments.edited != 1 {
+			t.Fatalf("Expected the existing comment to be edited, but got %d created and %d edited", comments.created, comments.edited)
+		}
+	})
+
+	t.Run("forgets merged and closed pull requests", func(t *testing.T) {
+		rep := newReporter("test", "test", "master", &fakeStatusCreator{}, &fakeCommentClient{})
+
+		rep.Block(reportedPullRequest("a"), "is not mergeable")
+		rep.Merged(reportedPullRequest("b"))
+		if len(rep.reported) != 0 || len(rep.blocked) != 0 || len(rep.commentIDs) != 0 {
+			t.Fatalf("Expected merged pull requests to be forgotten, but got %v, %v and %v", rep.reported, rep.blocked, rep.commentIDs)
+		}
+
+		rep.Block(reportedPullRequest("c"), "is not mergeable")
+		rep.Forget(reportedPullRequest("c"))
+		if len(rep.reported) != 0 || len(rep.blocked) != 0 || len(rep.commentIDs) != 0 {
+			t.Fatalf("Expected closed pull requests to be forgotten, but got %v, %v and %v", rep.reported, rep.blocked, rep.commentIDs)
+		}
+	})
+
+	t.Run("nil reporters report nothing", func(t *testing.T) {
+		var rep *reporter
+		rep.Progress(reportedPullRequest("a"), "queued")
+		rep.Block(reportedPullRequest("a"), "is not mergeable")
+		rep.Merged(reportedPullRequest("a"))
+		rep.Forget(reportedPullRequest("a"))
+	})
+}
+
+func TestRebaseFailure(t *testing.T) {
+	conflict := &repo.RebaseConflictError{
+		Branch:   "feature",
+		Mainline: "master",
+		Commit:   "0123456789abcdef0123456789abcdef01234567",
+		Subject:  "Add feature",
+		Paths:    []string{"README.md", "main.go"},
+	}
+	expected := `commit 0123456 "Add feature" of feature does not apply onto master, conflicts in README.md, main.go`
+	if reason := rebaseFailure("master", conflict); reason != expected {
+		t.Fatalf("Expected %q, but got %q", expected, reason)
+	}
+	if reason := rebaseFailure("master", errors.New("exit status 1")); reason != "rebasing onto master failed: exit status 1" {
+		t.Fatalf("Unexpected reason %q", reason)
+	}
+}
+
+type fakeJournal struct {
+	stages []journal.Stage
+}
+
+func (f *fakeJournal) Record(_ *github.PullRequest, stage journal.Stage) error {
+	f.stages = append(f.stages, stage)
+	return nil
+}
+
+func (f *fakeJournal) Remove(_ *github.PullRequest) error {
+	return nil
+}
+
+func TestStageReporter(t *testing.T) {
+	statuses := &fakeStatusCreator{}
+	j := &fakeJournal{}
+	s := stageReporter{journal: j, reporter: newReporter("test", "test", "master", statuses, nil)}
+
+	for _, stage := range []journal.Stage{journal.StageVerified, journal.StageRebasing, journal.StagePushed} {
+		if err := s.Record(reportedPullRequest("a"), stage); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if len(j.stages) != 3 {
+		t.Fatalf("Expected 3 recorded stages, but got %v", j.stages)
+	}
+	if len(statuses.statuses) != 2 || statuses.statuses[1].GetDescription() != "rebasing onto master" {
+		t.Fatalf("Unexpected statuses %v", statuses.statuses)
+	}
+}
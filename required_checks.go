@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
+)
+
+// RequiredStatusChecksGetter queries github for the required status checks of a protected branch
+type RequiredStatusChecksGetter interface {
+	GetRequiredStatusChecks(context.Context, string, string, string) (*github.RequiredStatusChecks, *github.Response, error)
+}
+
+// requiredChecks returns the names of status contexts and check runs which must
+// succeed before merging. Without required checks every status and check run must succeed
+type requiredChecks func() []string
+
+// staticChecks always requires the given checks
+func staticChecks(names []string) requiredChecks {
+	return func() []string {
+		return names
+	}
+}
+
+// protectedChecks requires the status checks configured in the branch protection
+// of branch. The protection is cached for ttl; if it can't be read the fallback
+// checks are required instead. Only server and network errors skip the cache
+func protectedChecks(client RequiredStatusChecksGetter, owner, name, branch string, fallback []string, ttl time.Duration) requiredChecks {
+	var (
+		mu      sync.Mutex
+		names   []string
+		fetched time.Time
+	)
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !fetched.IsZero() && time.Since(fetched) < ttl {
+			return names
+		}
+
+		protection, resp, err := client.GetRequiredStatusChecks(context.Background(), owner, name, branch)
+		if err != nil {
+			log.Printf("%s/%s: failed to read required checks of %s, using configured checks: %v\n", owner, name, branch, err)
+			// unprotected branches respond with 404 every time
+			if resp != nil && resp.Response != nil && resp.StatusCode < 500 {
+				names, fetched = fallback, time.Now()
+			}
+			return fallback
+		}
+		names, fetched = protection.Contexts, time.Now()
+		return names
+	}
+}
+
+// checkState evaluates the statuses and check runs of a commit and returns the
+// resulting state together with a reason. Only required checks are considered
+// if there are any; missing required checks are pending
+func checkState(required []string, status *github.CombinedStatus, runs []*checks.CheckRun) (string, string) {
+	if len(required) == 0 {
+		state := combinedState(status, runs)
+		return state, fmt.Sprintf("status is %s", state)
+	}
+
+	states := map[string]string{}
+	for _, s := range status.Statuses {
+		if s.GetContext() != statusContext {
+			states[s.GetContext()] = s.GetState()
+		}
+	}
+	// reruns create new check runs with the same name; the latest one counts
+	latest := map[string]*checks.CheckRun{}
+	for _, run := range runs {
+		if l, ok := latest[run.GetName()]; !ok || run.GetID() > l.GetID() {
+			latest[run.GetName()] = run
+		}
+	}
+	for name, run := range latest {
+		states[name] = checks.State([]*checks.CheckRun{run})
+	}
+
+	pending := ""
+	for _, name := range required {
+		state, ok := states[name]
+		if !ok {
+			state = "pending"
+		}
+		switch state {
+		case "success":
+		case "pending":
+			if pending == "" {
+				if ok {
+					pending = fmt.Sprintf("required check %q is pending", name)
+				} else {
+					pending = fmt.Sprintf("required check %q is missing", name)
+				}
+			}
+		default:
+			return state, fmt.Sprintf("required check %q is %s", name, state)
+		}
+	}
+	if pending != "" {
+		return "pending", pending
+	}
+	return "success", "required checks succeeded"
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
+)
+
+type fakeRequiredStatusChecksGetter func() (*github.RequiredStatusChecks, *github.Response, error)
+
+func (f fakeRequiredStatusChecksGetter) GetRequiredStatusChecks(_ context.Context, _, _, _ string) (*github.RequiredStatusChecks, *github.Response, error) {
+	return f()
+}
+
+func TestCheckState(t *testing.T) {
+	status := &github.CombinedStatus{
+		State:      stringVal("failure"),
+		TotalCount: intVal(2),
+		Statuses: []github.RepoStatus{
+			{Context: stringVal("ci/test"), State: stringVal("success")},
+			{Context: stringVal("coverage"), State: stringVal("failure")},
+		},
+	}
+	runs := []*checks.CheckRun{
+		{ID: int64Val(1), Name: stringVal("build"), Status: stringVal("completed"), Conclusion: stringVal("failure")},
+		{ID: int64Val(2), Name: stringVal("build"), Status: stringVal("completed"), Conclusion: stringVal("success")},
+		{ID: int64Val(3), Name: stringVal("lint"), Status: stringVal("in_progress")},
+	}
+
+	for name, tc := range map[string]struct {
+		required []string
+		state    string
+		reason   string
+	}{
+		"without required checks": {
+			state:  "failure",
+			reason: "status is failure",
+		},
+		"ignores optional checks": {
+			required: []string{"ci/test", "build"},
+			state:    "success",
+			reason:   "required checks succeeded",
+		},
+		"missing checks are pending": {
+			required: []string{"ci/test", "deploy"},
+			state:    "pending",
+			reason:   `required check "deploy" is missing`,
+		},
+		"running checks are pending": {
+			required: []string{"lint"},
+			state:    "pending",
+			reason:   `required check "lint" is pending`,
+		},
+		"failures take precedence": {
+			required: []string{"deploy", "coverage"},
+			state:    "failure",
+			reason:   `required check "coverage" is failure`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			state, reason := checkState(tc.required, status, runs)
+			if state != tc.state || reason != tc.reason {
+				t.Fatalf("Expected %s (%s), but got %s (%s)", tc.state, tc.reason, state, reason)
+			}
+		})
+	}
+}
+
+func TestProtectedChecks(t *testing.T) {
+	t.Run("caches the branch protection", func(t *testing.T) {
+		calls := 0
+		required := protectedChecks(fakeRequiredStatusChecksGetter(func() (*github.RequiredStatusChecks, *github.Response, error) {
+			calls++
+			return &github.RequiredStatusChecks{Contexts: []string{"ci/test"}}, nil, nil
+		}), "test", "test", "master", nil, time.Minute)
+
+		for i := 0; i < 2; i++ {
+			if names := required(); len(names) != 1 || names[0] != "ci/test" {
+				t.Fatalf("Expected protected checks, but got %v", names)
+			}
+		}
+		if calls != 1 {
+			t.Fatalf("Expected 1 call, but got %d", calls)
+		}
+	})
+
+	t.Run("falls back to configured checks", func(t *testing.T) {
+		required := protectedChecks(fakeRequiredStatusChecksGetter(func() (*github.RequiredStatusChecks, *github.Response, error) {
+			return nil, nil, errors.New("not found")
+		}), "test", "test", "master", []string{"build"}, time.Minute)
+
+		if names := required(); len(names) != 1 || names[0] != "build" {
+			t.Fatalf("Expected configured checks, but got %v", names)
+		}
+	})
+
+	t.Run("caches the fallback of unprotected branches", func(t *testing.T) {
+		for status, expected := range map[int]int{
+			http.StatusNotFound:            1,
+			http.StatusInternalServerError: 2,
+		} {
+			calls := 0
+			required := protectedChecks(fakeRequiredStatusChecksGetter(func() (*github.RequiredStatusChecks, *github.Response, error) {
+				calls++
+				resp := &github.Response{Response: &http.Response{StatusCode: status}}
+				return nil, resp, errors.New(http.StatusText(status))
+			}), "test", "test", "master", []string{"build"}, time.Minute)
+
+			for i := 0; i < 2; i++ {
+				if names := required(); len(names) != 1 || names[0] != "build" {
+					t.Fatalf("Expected configured checks, but got %v", names)
+				}
+			}
+			if calls != expected {
+				t.Errorf("Expected %d calls after %d, but got %d", expected, status, calls)
+			}
+		}
+	})
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// reviewPolicy describes the reviews a pull request needs before it's merged
+type reviewPolicy struct {
+	// Approvals is the minimum number of approving reviews
+	Approvals int `json:"approvals,omitempty"`
+	// CodeOwners requires an approval from an owner of every changed file listed in CODEOWNERS
+	CodeOwners bool `json:"codeowners,omitempty"`
+	// Teams require an approval from a member of every team, given as org/team
+	Teams []string `json:"teams,omitempty"`
+	// DismissStaleApprovals dismisses approvals once the bot pushed a rebased branch
+	DismissStaleApprovals bool `json:"dismiss_stale_approvals,omitempty"`
+}
+
+// enabled reports if the policy requires any reviews
+func (p reviewPolicy) enabled() bool {
+	return p.Approvals > 0 || p.CodeOwners || len(p.Teams) > 0
+}
+
+// approvalChecker returns why a pull request lacks approval, or an empty string if it's approved
+type approvalChecker func(*github.PullRequest) (string, error)
+
+// ReviewLister lists the reviews of a pull request
+type ReviewLister interface {
+	ListReviews(context.Context, string, string, int, *github.ListOptions) ([]*github.PullRequestReview, *github.Response, error)
+}
+
+// FileLister lists the files changed by a pull request
+type FileLister interface {
+	ListFiles(context.Context, string, string, int, *github.ListOptions) ([]*github.CommitFile, *github.Response, error)
+}
+
+// ContentGetter reads files from a repository
+type ContentGetter interface {
+	GetContents(context.Context, string, string, string, *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error)
+}
+
+// TeamMemberChecker resolves teams and their members
+type TeamMemberChecker interface {
+	ListTeams(context.Context, string, *github.ListOptions) ([]*github.Team, *github.Response, error)
+	IsTeamMember(context.Context, int, string) (bool, *github.Response, error)
+}
+
+// ReviewDismisser dismisses reviews of a pull request
+type ReviewDismisser interface {
+	ListReviews(context.Context, string, string, int, *github.ListOptions) ([]*github.PullRequestReview, *github.Response, error)
+	DismissReview(context.Context, string, string, int, int, *github.PullRequestReviewDismissalRequest) (*github.PullRequestReview, *github.Response, error)
+}
+
+// codeOwnersPaths are the locations github reads CODEOWNERS from, in order
+var codeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// listReviews lists all reviews of a pull request, oldest first
+func listReviews(client ReviewLister, owner, name string, number int) ([]*github.PullRequestReview, error) {
+	var reviews []*github.PullRequestReview
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.ListReviews(context.Background(), owner, name, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return reviews, nil
+}
+
+// reviewer enforces a review policy
+type reviewer struct {
+	policy   reviewPolicy
+	reviews  ReviewLister
+	files    FileLister
+	contents ContentGetter
+	teams    TeamMemberChecker
+	// ttl controls how long CODEOWNERS and team ids are cached
+	ttl time.Duration
+
+	mu        sync.Mutex
+	owners    []codeOwnersRule
+	fetched   time.Time
+	teamIDs   map[string]int
+	teamsRead time.Time
+}
+
+// check returns why a pull request does not satisfy the review policy, or an
+// empty string if it does
+func (r *reviewer) check(pr *github.PullRequest) (string, error) {
+	owner := pr.Base.Repo.Owner.GetLogin()
+	name := pr.Base.Repo.GetName()
+
+	reviews, err := listReviews(r.reviews, owner, name, pr.GetNumber())
+	if err != nil {
+		return "", err
+	}
+
+	// only the latest review of every reviewer counts. Comments do not change the verdict
+	latest := map[string]string{}
+	order := []string{}
+	for _, review := range reviews {
+		login := review.User.GetLogin()
+		if strings.EqualFold(login, pr.User.GetLogin()) {
+			continue
+		}
+		switch state := review.GetState(); state {
+		case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+			if _, ok := latest[login]; !ok {
+				order = append(order, login)
+			}
+			latest[login] = state
+		}
+	}
+
+	approvers := []string{}
+	for _, login := range order {
+		switch latest[login] {
+		case "CHANGES_REQUESTED":
+			return fmt.Sprintf("has changes requested by @%s", login), nil
+		case "APPROVED":
+			approvers = append(approvers, login)
+		}
+	}
+
+	if len(approvers) < r.policy.Approvals {
+		return fmt.Sprintf("has %d of %d required approvals", len(approvers), r.policy.Approvals), nil
+	}
+
+	for _, team := range r.policy.Teams {
+		ok, err := r.approvedBy(approvers, []string{"@" + team})
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return fmt.Sprintf("lacks approval from team %s", team), nil
+		}
+	}
+
+	if !r.policy.CodeOwners {
+		return "", nil
+	}
+	rules, err := r.codeOwners(owner, name, pr.Base.GetRef())
+	if err != nil {
+		return "", err
+	}
+	files, err := r.changedFiles(owner, name, pr.GetNumber())
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		owners := ownersOf(rules, file)
+		if len(owners) == 0 {
+			continue
+		}
+		ok, err := r.approvedBy(approvers, owners)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return fmt.Sprintf("lacks approval from code owners of %s", file), nil
+		}
+	}
+	return "", nil
+}
+
+// approvedBy reports if any approver is one of the owners. Owners are @user or @org/team
+func (r *reviewer) approvedBy(approvers, owners []string) (bool, error) {
+	for _, o := range owners {
+		o = strings.TrimPrefix(o, "@")
+		if !strings.Contains(o, "/") {
+			for _, approver := range approvers {
+				if strings.EqualFold(approver, o) {
+					return true, nil
+				}
+			}
+			continue
+		}
+
+		id, err := r.teamID(o)
+		if err != nil {
+			return false, err
+		}
+		for _, approver := range approvers {
+			member, _, err := r.teams.IsTeamMember(context.Background(), id, approver)
+			if err != nil {
+				return false, err
+			}
+			if member {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// teamID resolves org/team to the id of the team
+func (r *reviewer) teamID(team string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.teamIDs == nil || time.Since(r.teamsRead) >= r.ttl {
+		r.teamIDs = map[string]int{}
+		r.teamsRead = time.Now()
+	}
+	key := strings.ToLower(team)
+	if id, ok := r.teamIDs[key]; ok {
+		return id, nil
+	}
+
+	org := strings.SplitN(team, "/", 2)[0]
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := r.teams.ListTeams(context.Background(), org, opt)
+		if err != nil {
+			return 0, err
+		}
+		for _, t := range teams {
+			r.teamIDs[strings.ToLower(fmt.Sprintf("%s/%s", org, t.GetSlug()))] = t.GetID()
+		}
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	id, ok := r.teamIDs[key]
+	if !ok {
+		return 0, fmt.Errorf("team %s not found", team)
+	}
+	return id, nil
+}
+
+// changedFiles lists the paths changed by a pull request
+func (r *reviewer) changedFiles(owner, name string, number int) ([]string, error) {
+	paths := []string{}
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := r.files.ListFiles(context.Background(), owner, name, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			paths = append(paths, f.GetFilename())
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return paths, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// codeOwners reads and caches the CODEOWNERS rules of a branch
+func (r *reviewer) codeOwners(owner, name, branch string) ([]codeOwnersRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.fetched.IsZero() && time.Since(r.fetched) < r.ttl {
+		return r.owners, nil
+	}
+
+	for _, p := range codeOwnersPaths {
+		file, _, resp, err := r.contents.GetContents(context.Background(), owner, name, p, &github.RepositoryContentGetOptions{Ref: branch})
+		if resp != nil && resp.StatusCode == 404 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, err
+		}
+		r.owners, r.fetched = parseCodeOwners(content), time.Now()
+		return r.owners, nil
+	}
+
+	log.Printf("%s/%s: no CODEOWNERS found on %s\n", owner, name, branch)
+	r.owners, r.fetched = nil, time.Now()
+	return nil, nil
+}
+
+// codeOwnersRule assigns owners to all paths matching a pattern
+type codeOwnersRule struct {
+	pattern string
+	owners  []string
+}
+
+// parseCodeOwners parses the rules of a CODEOWNERS file. Owners given by email are ignored
+func parseCodeOwners(content string) []codeOwnersRule {
+	rules := []codeOwnersRule{}
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rule := codeOwnersRule{pattern: fields[0]}
+		for _, o := range fields[1:] {
+			if strings.HasPrefix(o, "@") {
+				rule.owners = append(rule.owners, o)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ownersOf returns the owners of a file. The last matching rule wins
+func ownersOf(rules []codeOwnersRule, file string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if matchCodeOwners(rules[i].pattern, file) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// matchCodeOwners matches a file against a gitignore style CODEOWNERS pattern
+func matchCodeOwners(pattern, file string) bool {
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if !anchored && !strings.Contains(strings.TrimSuffix(pattern, "/**"), "/") {
+		pattern = "**/" + pattern
+	}
+
+	patternParts := strings.Split(pattern, "/")
+	fileParts := strings.Split(file, "/")
+	// a pattern matching a directory matches everything below it
+	for n := len(fileParts); n > 0; n-- {
+		if matchSegments(patternParts, fileParts[:n]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments, where ** matches any number of segments
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
+
+// dismissApprovals dismisses all approving reviews of a pull request
+func dismissApprovals(client ReviewDismisser, pr *github.PullRequest, message string) {
+	owner := pr.Base.Repo.Owner.GetLogin()
+	name := pr.Base.Repo.GetName()
+
+	reviews, err := listReviews(client, owner, name, pr.GetNumber())
+	if err != nil {
+		log.Printf("%s/%s: pr %d failed to list reviews: %v\n", owner, name, pr.GetNumber(), err)
+		return
+	}
+	for _, review := range reviews {
+		if review.GetState() != "APPROVED" {
+			continue
+		}
+		if _, _, err := client.DismissReview(context.Background(), owner, name, pr.GetNumber(), review.GetID(), &github.PullRequestReviewDismissalRequest{
+			Message: &message,
+		}); err != nil {
+			log.Printf("%s/%s: pr %d failed to dismiss review %d: %v\n", owner, name, pr.GetNumber(), review.GetID(), err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+type fakeReviews []*github.PullRequestReview
+
+func (f fakeReviews) ListReviews(_ context.Context, _, _ string, _ int, _ *github.ListOptions) ([]*github.PullRequestReview, *github.Response, error) {
+	return f, &github.Response{}, nil
+}
+
+// pagedReviews returns one page of reviews per request
+type pagedReviews [][]*github.PullRequestReview
+
+func (f pagedReviews) ListReviews(_ context.Context, _, _ string, _ int, opt *github.ListOptions) ([]*github.PullRequestReview, *github.Response, error) {
+	page := opt.Page
+	if page == 0 {
+		page = 1
+	}
+	resp := &github.Response{}
+	if page < len(f) {
+		resp.NextPage = page + 1
+	}
+	return f[page-1], resp, nil
+}
+
+type fakeFiles []string
+
+func (f fakeFiles) ListFiles(_ context.Context, _, _ string, _ int, _ *github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+	files := []*github.CommitFile{}
+	for _, name := range f {
+		files = append(files, &github.CommitFile{Filename: stringVal(name)})
+	}
+	return files, nil, nil
+}
+
+// fakeContents serves files by path and responds with 404 for everything else
+type fakeContents map[string]string
+
+func (f fakeContents) GetContents(_ context.Context, _, _, path string, _ *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error) {
+	content, ok := f[path]
+	if !ok {
+		resp := &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+		return nil, nil, resp, &github.ErrorResponse{Response: resp.Response}
+	}
+	return &github.RepositoryContent{Content: stringVal(content)}, nil, nil, nil
+}
+
+// fakeTeams knows a single team per org called reviewers
+type fakeTeams map[string][]string
+
+func (f fakeTeams) ListTeams(_ context.Context, org string, _ *github.ListOptions) ([]*github.Team, *github.Response, error) {
+	return []*github.Team{{ID: intVal(1), Slug: stringVal("reviewers")}}, nil, nil
+}
+
+func (f fakeTeams) IsTeamMember(_ context.Context, _ int, user string) (bool, *github.Response, error) {
+	for _, member := range f["reviewers"] {
+		if member == user {
+			return true, nil, nil
+		}
+	}
+	return false, nil, nil
+}
+
+func review(login, state string) *github.PullRequestReview {
+	return &github.PullRequestReview{
+		ID:    intVal(len(login)),
+		User:  &github.User{Login: stringVal(login)},
+		State: stringVal(state),
+	}
+}
+
+func reviewedPullRequest() *github.PullRequest {
+	return &github.PullRequest{
+		Number: intVal(1),
+		User:   &github.User{Login: stringVal("author")},
+		Base: &github.PullRequestBranch{
+			Ref: stringVal("master"),
+			Repo: &github.Repository{
+				Owner: &github.User{Login: stringVal("test")},
+				Name:  stringVal("test"),
+			},
+		},
+	}
+}
+
+func TestReviewer_check(t *testing.T) {
+	for name, tc := range map[string]struct {
+		policy  reviewPolicy
+		reviews fakeReviews
+		files   fakeFiles
+		reason  string
+	}{
+		"enough approvals": {
+			policy:  reviewPolicy{Approvals: 2},
+			reviews: fakeReviews{review("a", "APPROVED"), review("b", "COMMENTED"), review("b", "APPROVED")},
+		},
+		"missing approvals": {
+			policy:  reviewPolicy{Approvals: 2},
+			reviews: fakeReviews{review("a", "APPROVED"), review("author", "APPROVED")},
+			reason:  "has 1 of 2 required approvals",
+		},
+		"dismissed approvals": {
+			policy:  reviewPolicy{Approvals: 1},
+			reviews: fakeReviews{review("a", "DISMISSED"), review("b", "COMMENTED")},
+			reason:  "has 0 of 1 required approvals",
+		},
+		"outstanding changes requested": {
+			policy:  reviewPolicy{Approvals: 1},
+			reviews: fakeReviews{review("a", "APPROVED"), review("b", "CHANGES_REQUESTED"), review("b", "COMMENTED")},
+			reason:  "has changes requested by @b",
+		},
+		"resolved changes requested": {
+			policy:  reviewPolicy{Approvals: 1},
+			reviews: fakeReviews{review("b", "CHANGES_REQUESTED"), review("b", "APPROVED")},
+		},
+		"team approval": {
+			policy:  reviewPolicy{Teams: []string{"test/reviewers"}},
+			reviews: fakeReviews{review("member", "APPROVED")},
+		},
+		"missing team approval": {
+			policy:  reviewPolicy{Teams: []string{"test/reviewers"}},
+			reviews: fakeReviews{review("a", "APPROVED")},
+			reason:  "lacks approval from team test/reviewers",
+		},
+		"code owner approval": {
+			policy:  reviewPolicy{CodeOwners: true},
+			reviews: fakeReviews{review("gopher", "APPROVED"), review("member", "APPROVED")},
+			files:   fakeFiles{"README.md", "main.go", "docs/index.md"},
+		},
+		"missing code owner approval": {
+			policy:  reviewPolicy{CodeOwners: true},
+			reviews: fakeReviews{review("gopher", "APPROVED")},
+			files:   fakeFiles{"main.go", "docs/index.md"},
+			reason:  "lacks approval from code owners of docs/index.md",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := &reviewer{
+				policy:  tc.policy,
+				reviews: tc.reviews,
+				files:   tc.files,
+				contents: fakeContents{
+					"CODEOWNERS": "# owners\n*.go @gopher\n/docs/ @test/reviewers owner@example.com\n",
+				},
+				teams: fakeTeams{"reviewers": {"member"}},
+				ttl:   time.Minute,
+			}
+			reason, err := r.check(reviewedPullRequest())
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if reason != tc.reason {
+				t.Fatalf("Expected %q, but got %q", tc.reason, reason)
+			}
+		})
+	}
+}
+
+func TestReviewer_ListsAllPages(t *testing.T) {
+	r := &reviewer{
+		policy: reviewPolicy{Approvals: 1},
+		reviews: pagedReviews{
+			{review("a", "APPROVED")},
+			{review("b", "COMMENTED"), review("a", "CHANGES_REQUESTED")},
+		},
+		ttl: time.Minute,
+	}
+	reason, err := r.check(reviewedPullRequest())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected := "has changes requested by @a"; reason != expected {
+		t.Fatalf("Expected %q, but got %q", expected, reason)
+	}
+}
+
+func TestMatchCodeOwners(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"*", "a/b/c.go", true},
+		{"*.go", "main.go", true},
+		{"*.go", "a/b/c.go", true},
+		{"*.go", "README.md", false},
+		{"/docs/", "docs/a/b.md", true},
+		{"/docs/", "src/docs/b.md", false},
+		{"docs/", "src/docs/b.md", true},
+		{"apps/", "apps/web/index.js", true},
+		{"/build/logs/", "build/logs/today.log", true},
+		{"src/*.js", "src/index.js", true},
+		{"src/*.js", "lib/src/index.js", false},
+		{"src/*.js", "src/lib/index.js", false},
+		{"**/logs", "a/b/logs/today.log", true},
+		{"/README.md", "README.md", true},
+	} {
+		if match := matchCodeOwners(tc.pattern, tc.file); match != tc.match {
+			t.Errorf("Expected %q matching %q to be %v", tc.pattern, tc.file, tc.match)
+		}
+	}
+}
+
+type fakeReviewDismisser struct {
+	fakeReviews
+	dismissed []int
+}
+
+func (f *fakeReviewDismisser) DismissReview(_ context.Context, _, _ string, _, id int, _ *github.PullRequestReviewDismissalRequest) (*github.PullRequestReview, *github.Response, error) {
+	f.dismissed = append(f.dismissed, id)
+	return nil, nil, nil
+}
+
+func TestDismissApprovals(t *testing.T) {
+	client := &fakeReviewDismisser{fakeReviews: fakeReviews{
+		review("a", "APPROVED"),
+		review("bb", "COMMENTED"),
+		review("ccc", "APPROVED"),
+	}}
+	dismissApprovals(client, reviewedPullRequest(), "rebased")
+
+	if len(client.dismissed) != 2 || client.dismissed[0] != 1 || client.dismissed[1] != 3 {
+		t.Fatalf("Expected approvals to be dismissed, but got %v", client.dismissed)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/journal"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+func eventPath(owner, name string) string {
+	return fmt.Sprintf("/events/%s/%s", owner, name)
+}
+
+// appEventPath receives the webhooks of a github app for all its repositories
+const appEventPath = "/events"
+
+// router dispatches webhook requests to the pipeline of a repository.
+// Unlike http.ServeMux handlers can be removed at runtime
+type router struct {
+	mu       sync.RWMutex
+	handlers map[string]http.Handler
+}
+
+func newRouter() *router {
+	return &router{handlers: make(map[string]http.Handler)}
+}
+
+func (rt *router) Handle(path string, h http.Handler) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.handlers[path] = h
+}
+
+func (rt *router) Remove(path string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.handlers, path)
+}
+
+// Lookup returns the handler of a path. Paths are compared case insensitive, since
+// github does not preserve the casing of owners and names in all payloads
+func (rt *router) Lookup(path string) (http.Handler, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	if h, ok := rt.handlers[path]; ok {
+		return h, true
+	}
+	for p, h := range rt.handlers {
+		if strings.EqualFold(p, path) {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rt.mu.RLock()
+	h, ok := rt.handlers[req.URL.Path]
+	rt.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	h.ServeHTTP(w, req)
+}
+
+// running is a repository with an active pipeline
+type running struct {
+	repository
+	client   *github.Client
+	cache    *repo.Cache
+	pipeline *pipeline
+}
+
+// supervisor starts and stops repository pipelines as the configuration changes
+type supervisor struct {
+	creds credentials
+	host  githubHost
+	// identity is the default committer of all repositories
+	identity repo.Identity
+	// app is set if events are delivered by a github app instead of repository webhooks
+	app       bool
+	publicDNS string
+	secret    webhookSecret
+	router    *router
+	journal   *journal.Journal
+
+	mu      sync.Mutex
+	running map[string]*running
+	// desired contains the repositories of the latest configuration
+	desired map[string]repository
+	// draining contains changed repositories waiting for their old pipeline to drain
+	draining map[string]bool
+	// teardown tracks pipelines of removed and changed repositories which are still draining
+	teardown sync.WaitGroup
+}
+
+func newSupervisor(creds credentials, host githubHost, publicDNS string, secret webhookSecret, j *journal.Journal) *supervisor {
+	return &supervisor{
+		creds:     creds,
+		host:      host,
+		publicDNS: publicDNS,
+		secret:    secret,
+		journal:   j,
+		router:    newRouter(),
+		running:   make(map[string]*running),
+		desired:   make(map[string]repository),
+		draining:  make(map[string]bool),
+	}
+}
+
+// diffRepositories compares the running repositories with the desired ones
+func diffRepositories(current map[string]*running, desired repositories) (added, removed, changed repositories) {
+	seen := map[string]bool{}
+	for _, r := range desired {
+		seen[r.key()] = true
+		cur, ok := current[r.key()]
+		if !ok {
+			added = append(added, r)
+		} else if !reflect.DeepEqual(cur.config, r.config) {
+			changed = append(changed, r)
+		}
+	}
+	for key, cur := range current {
+		if !seen[key] {
+			removed = append(removed, cur.repository)
+		}
+	}
+	return added, removed, changed
+}
+
+// Apply reconciles the running pipelines with the given repositories.
+// Removed and changed repositories are drained in the background; changed
+// repositories are restarted with the latest configuration once drained.
+func (s *supervisor) Apply(rs repositories) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.desired = make(map[string]repository)
+	for _, r := range rs {
+		s.desired[r.key()] = r
+	}
+
+	added, removed, changed := diffRepositories(s.running, rs)
+	for _, r := range removed {
+		log.Printf("%s/%s: removing repository\n", r.Owner, r.Name)
+		cur := s.running[r.key()]
+		delete(s.running, r.key())
+		s.router.Remove(eventPath(r.Owner, r.Name))
+
+		s.teardown.Add(1)
+		go func(cur *running) {
+			defer s.teardown.Done()
+			cur.pipeline.Stop()
+			s.remove(cur)
+		}(cur)
+	}
+
+	for _, r := range changed {
+		log.Printf("%s/%s: reloading repository\n", r.Owner, r.Name)
+		cur := s.running[r.key()]
+		delete(s.running, r.key())
+		s.router.Remove(eventPath(r.Owner, r.Name))
+		s.draining[r.key()] = true
+
+		s.teardown.Add(1)
+		go func(cur *running) {
+			defer s.teardown.Done()
+			s.reload(cur)
+		}(cur)
+	}
+
+	var errs []string
+	for _, r := range added {
+		if s.draining[r.key()] {
+			// restarted with the latest configuration once its old pipeline drained
+			continue
+		}
+		log.Printf("%s/%s: adding repository\n", r.Owner, r.Name)
+		if err := s.start(r, nil); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// remove deletes the webhook, journal entries and cache of a stopped repository
+func (s *supervisor) remove(cur *running) {
+	s.deleteHook(cur)
+	if err := s.journal.Purge(cur.Owner, cur.Name); err != nil {
+		log.Printf("%s/%s: purging journal failed: %v\n", cur.Owner, cur.Name, err)
+	}
+	if err := cur.cache.Close(); err != nil {
+		log.Printf("%s/%s: removing cache failed: %v\n", cur.Owner, cur.Name, err)
+	}
+}
+
+// reload drains the pipeline of a changed repository without holding the lock,
+// so slow repositories don't block others. Afterwards the repository is started
+// again with the configuration desired by then
+func (s *supervisor) reload(cur *running) {
+	cur.pipeline.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.draining, cur.key())
+
+	r, ok := s.desired[cur.key()]
+	if !ok {
+		log.Printf("%s/%s: removing repository\n", cur.Owner, cur.Name)
+		s.remove(cur)
+		return
+	}
+	// hooks which are still registered are updated in place by start
+	if s.publicDNS == "" || !r.registerHook || s.app {
+		s.deleteHook(cur)
+	}
+
+	cache := cur.cache
+	if cur.Mainline != r.Mainline {
+		cache.Close()
+		cache = nil
+	}
+	if err := s.start(r, cache); err != nil {
+		log.Printf("reloading failed: %v\n", err)
+	}
+}
+
+// start prepares the cache of a repository, starts its pipeline and registers its webhook
+func (s *supervisor) start(r repository, cache *repo.Cache) error {
+	r.secret = s.secret.For(r.Owner, r.Name)
+	if r.config.Hook.Secret != "" {
+		r.secret = r.config.Hook.Secret
+	}
+	if s.app {
+		// github apps sign all deliveries with the secret configured for the app
+		r.secret = string(s.secret.key)
+		r.registerHook = false
+	}
+
+	client, err := s.creds.Client(r.Owner, r.Name)
+	if err != nil {
+		return err
+	}
+	if cache == nil {
+		// tokens of github apps expire, so git asks for the current one with every command
+		token := func() (string, error) {
+			return s.creds.Token(r.Owner, r.Name)
+		}
+		c, err := repo.PrepareWithCredentials(s.host.CloneURL(fmt.Sprintf("%s/%s", r.Owner, r.Name)), r.Mainline, token)
+		if err != nil {
+			return fmt.Errorf("%s/%s: prepare failed: %v", r.Owner, r.Name, err)
+		}
+		cache = c
+	}
+	if err := cache.UseIdentity(r.config.Commits.identity(s.identity)); err != nil {
+		return fmt.Errorf("%s/%s: configuring commit identity failed: %v", r.Owner, r.Name, err)
+	}
+	cache.UseStrategy(r.updateStrategy, branchUpdater{client: client, owner: r.Owner, name: r.Name})
+	r.Cache = cache
+	r.Journal = s.journal
+	r.ForkURL = s.host.CloneURL
+
+	p := prHandler(r, client, s.journal.Entries(r.Owner, r.Name))
+	s.router.Handle(eventPath(r.Owner, r.Name), p)
+
+	if s.publicDNS != "" && r.registerHook {
+		h, err := registerHook(client, s.publicDNS, r.Owner, r.Name, r.secret, r.hookEvents)
+		if err != nil {
+			log.Printf("%s/%s: registering hook failed: %v\n", r.Owner, r.Name, err)
+		}
+		r.hook = h
+	}
+
+	s.running[r.key()] = &running{repository: r, client: client, cache: cache, pipeline: p}
+	return nil
+}
+
+func (s *supervisor) deleteHook(r *running) {
+	if r.hook == nil {
+		return
+	}
+	if _, err := r.client.Repositories.DeleteHook(context.Background(), r.Owner, r.Name, r.hook.GetID()); err != nil {
+		log.Printf("%s/%s: deleting hook failed: %v\n", r.Owner, r.Name, err)
+	}
+}
+
+// Shutdown waits for removed repositories to drain and deletes all webhooks
+// registered by the bot
+func (s *supervisor) Shutdown() {
+	s.teardown.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.running {
+		s.deleteHook(r)
+	}
+}
+
+// watchConfig reloads the configuration whenever the file changes or a signal
+// is received on reload. Invalid configurations are logged and ignored
+func watchConfig(path string, interval time.Duration, reload <-chan os.Signal, apply func(repositories) error) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-reload:
+			if !ok {
+				return
+			}
+			log.Printf("Received reload signal, reloading %s.\n", path)
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || !info.ModTime().After(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			log.Printf("%s changed, reloading.\n", path)
+		}
+
+		cfg, err := loadConfig(path)
+		if err != nil {
+			log.Printf("reloading configuration failed: %v\n", err)
+			continue
+		}
+		if err := cfg.validate(); err != nil {
+			log.Printf("invalid configuration: %v\n", err)
+			continue
+		}
+		if err := apply(cfg.repositories()); err != nil {
+			log.Printf("applying configuration failed: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
+)
+
+func TestRouter(t *testing.T) {
+	rt := newRouter()
+	rt.Handle("/events/test/test", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	t.Run("dispatches to registered handlers", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest("POST", "/events/test/test", nil))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected status %d, but got %d", http.StatusAccepted, rec.Code)
+		}
+	})
+
+	t.Run("responds 404 for removed handlers", func(t *testing.T) {
+		rt.Remove("/events/test/test")
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest("POST", "/events/test/test", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("Expected status %d, but got %d", http.StatusNotFound, rec.Code)
+		}
+	})
+}
+
+func TestDiffRepositories(t *testing.T) {
+	build := func(cfg config) repositories {
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err.Error())
+		}
+		return cfg.repositories()
+	}
+
+	old := build(config{Repositories: []repositoryConfig{
+		{Repository: "test/kept", MergeLabel: "LGTM"},
+		{Repository: "test/changed", MergeLabel: "LGTM"},
+		{Repository: "test/removed", MergeLabel: "LGTM"},
+	}})
+	current := map[string]*running{}
+	for _, r := range old {
+		current[r.key()] = &running{repository: r}
+	}
+
+	desired := build(config{Repositories: []repositoryConfig{
+		{Repository: "test/kept", MergeLabel: "LGTM"},
+		{Repository: "test/changed", MergeLabel: "ready"},
+		{Repository: "test/added", MergeLabel: "LGTM"},
+	}})
+
+	added, removed, changed := diffRepositories(current, desired)
+	if len(added) != 1 || added[0].Name != "added" {
+		t.Fatalf("Expected test/added to be added, but got %v", added)
+	}
+	if len(removed) != 1 || removed[0].Name != "removed" {
+		t.Fatalf("Expected test/removed to be removed, but got %v", removed)
+	}
+	if len(changed) != 1 || changed[0].Name != "changed" {
+		t.Fatalf("Expected test/changed to be changed, but got %v", changed)
+	}
+}
+
+// stuckPipeline is a pipeline which never finishes draining
+func stuckPipeline() *pipeline {
+	return &pipeline{
+		done:             make(chan struct{}),
+		quit:             make(chan struct{}),
+		issueQueue:       make(chan *github.IssuesEvent),
+		prQueue:          make(chan *github.PullRequest),
+		reviewQueue:      make(chan *github.PullRequestReviewEvent),
+		pushEventQueue:   make(chan *github.PushEvent),
+		statusEventQueue: make(chan *github.StatusEvent),
+		checkRunQueue:    make(chan *checks.CheckRunEvent),
+		checkSuiteQueue:  make(chan *checks.CheckSuiteEvent),
+		commentQueue:     make(chan *github.IssueCommentEvent),
+		rebaseQueue:      make(chan *github.PullRequest),
+	}
+}
+
+func TestSupervisor_ApplyDrainsChangedRepositoriesInBackground(t *testing.T) {
+	build := func(label string) repositories {
+		cfg := config{Repositories: []repositoryConfig{{Repository: "test/changed", MergeLabel: label}}}
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err.Error())
+		}
+		return cfg.repositories()
+	}
+
+	s := newSupervisor(nil, githubHost{}, "", webhookSecret{}, nil)
+	r := build("LGTM")[0]
+	s.running[r.key()] = &running{repository: r, pipeline: stuckPipeline()}
+
+	applied := make(chan error)
+	go func() {
+		applied <- s.Apply(build("ready"))
+		// repositories waiting for their old pipeline are not started twice
+		applied <- s.Apply(build("ready"))
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-applied:
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected Apply not to wait for the pipeline to drain")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.draining[r.key()] || s.running[r.key()] != nil {
+		t.Fatalf("Expected test/changed to be draining, but got %v and %v", s.draining, s.running)
+	}
+}
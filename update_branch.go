@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+// branchUpdater merges mainline into the branch of a pull request using
+// github's update branch API, without the bot pushing anything itself
+type branchUpdater struct {
+	client *github.Client
+	owner  string
+	name   string
+}
+
+// UpdateBranch updates the open pull request of a branch
+func (u branchUpdater) UpdateBranch(head repo.Head) error {
+	var pr *github.PullRequest
+	if head.Number != 0 {
+		p, _, err := u.client.PullRequests.Get(context.Background(), u.owner, u.name, head.Number)
+		if err != nil {
+			return err
+		}
+		pr = p
+	} else {
+		prs, _, err := u.client.PullRequests.List(context.Background(), u.owner, u.name, &github.PullRequestListOptions{
+			State: "open",
+			Head:  fmt.Sprintf("%s:%s", u.owner, head.Ref),
+		})
+		if err != nil {
+			return err
+		}
+		if len(prs) == 0 {
+			return fmt.Errorf("no open pull request for branch %s", head.Ref)
+		}
+		pr = prs[0]
+	}
+
+	req, err := u.client.NewRequest("PUT", fmt.Sprintf("repos/%v/%v/pulls/%d/update-branch", u.owner, u.name, pr.GetNumber()), map[string]string{
+		// github refuses the update if the branch moved in the meantime
+		"expected_head_sha": pr.Head.GetSHA(),
+	})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.lydian-preview+json")
+	_, err = u.client.Do(context.Background(), req, nil)
+	if _, ok := err.(*github.AcceptedError); ok {
+		// the branch is updated asynchronously, which triggers a synchronize event once done
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/repo"
+)
+
+func TestBranchUpdater(t *testing.T) {
+	var body map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/test/test/pulls", func(w http.ResponseWriter, r *http.Request) {
+		if head := r.URL.Query().Get("head"); head != "test:feature" {
+			t.Errorf("Unexpected head filter %q", head)
+		}
+		fmt.Fprint(w, `[{"number": 7, "head": {"sha": "abc"}}]`)
+	})
+	mux.HandleFunc("/repos/test/test/pulls/7/update-branch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("Unexpected method %s", r.Method)
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"message": "Updating pull request branch."}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	u := branchUpdater{client: client, owner: "test", name: "test"}
+	if err := u.UpdateBranch(repo.Head{Ref: "feature"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if body["expected_head_sha"] != "abc" {
+		t.Fatalf("Expected the head sha to be sent, but got %v", body)
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"strings"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
 )
 
 // StatusGetter fetches the status of a specific commit
@@ -13,18 +15,149 @@ type StatusGetter interface {
 	GetCombinedStatus(context.Context, string, string, string, *github.ListOptions) (*github.CombinedStatus, *github.Response, error)
 }
 
+// CheckRunLister lists the check runs of a specific commit
+type CheckRunLister interface {
+	ListCheckRunsForRef(context.Context, string, string, string) ([]*checks.CheckRun, *github.Response, error)
+}
+
+// commitState combines the statuses of a commit like github does, ignoring the
+// status reported by the bot itself. It reports false if there are no other statuses
+func commitState(status *github.CombinedStatus) (string, bool) {
+	if len(status.Statuses) == 0 {
+		return status.GetState(), status.GetTotalCount() > 0
+	}
+
+	state, n := "success", 0
+	for _, s := range status.Statuses {
+		if s.GetContext() == statusContext {
+			continue
+		}
+		n++
+		switch s.GetState() {
+		case "success":
+		case "pending":
+			state = "pending"
+		default:
+			return s.GetState(), true
+		}
+	}
+	if n == 0 {
+		// without any statuses github reports pending
+		return "pending", false
+	}
+	return state, true
+}
+
+// combinedState merges the combined status and the check runs of a commit.
+// Failures take precedence over pending statuses and check runs
+func combinedState(status *github.CombinedStatus, runs []*checks.CheckRun) string {
+	state, ok := commitState(status)
+	if len(runs) == 0 {
+		return state
+	}
+	if !ok {
+		return checks.State(runs)
+	}
+
+	states := []string{state, checks.State(runs)}
+	for _, state := range states {
+		if state != "success" && state != "pending" {
+			return state
+		}
+	}
+	for _, state := range states {
+		if state == "pending" {
+			return state
+		}
+	}
+	return "success"
+}
+
+// evaluateCommit reads the statuses and check runs of a commit and evaluates
+// them against the required checks. required may be nil to require all of them
+func evaluateCommit(statusClient StatusGetter, checkClient CheckRunLister, owner, name, sha string, required requiredChecks) (string, string, error) {
+	status, _, err := statusClient.GetCombinedStatus(
+		context.Background(),
+		owner,
+		name,
+		sha,
+		&github.ListOptions{PerPage: 100},
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	runs, _, err := checkClient.ListCheckRunsForRef(context.Background(), owner, name, sha)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list check runs: %v", err)
+	}
+
+	var names []string
+	if required != nil {
+		names = required()
+	}
+	state, reason := checkState(names, status, runs)
+	return state, reason, nil
+}
+
+// commitChecker evaluates commits which are not the head of a pull request,
+// e.g. the staging branch in batch mode, just like pull requests are verified
+type commitChecker struct {
+	owner    string
+	name     string
+	statuses StatusGetter
+	runs     CheckRunLister
+	required requiredChecks
+}
+
+// State evaluates the statuses and check runs of a commit
+func (c commitChecker) State(sha string) (string, string, error) {
+	return evaluateCommit(c.statuses, c.runs, c.owner, c.name, sha, c.required)
+}
+
 // IssueGetter queries github for a specific issue
 type IssueGetter interface {
 	Get(context.Context, string, string, int) (*github.Issue, *github.Response, error)
 }
 
-// verifyPullRequest filters out non-mergeable pull requests
-func verifyPullRequest(issueClient IssueGetter, statusClient StatusGetter, mergeLabel string, input <-chan *github.PullRequest) <-chan *github.PullRequest {
+// verdict classifies why a pull request was rejected
+type verdict int
+
+const (
+	// rejectPending pull requests might pass later without user interaction, e.g. once CI finishes
+	rejectPending verdict = iota
+	// rejectBlocked pull requests need user interaction, e.g. because they lack approval
+	rejectBlocked
+	// rejectFailed pull requests failed definitely, e.g. because of a conflict or a failing status
+	rejectFailed
+	// rejectIgnored pull requests are not meant to be merged, e.g. because they are closed or not labeled
+	rejectIgnored
+)
+
+// rejection explains why a pull request did not pass verification
+type rejection struct {
+	PR      *github.PullRequest
+	Verdict verdict
+	Reason  string
+}
+
+// verifyPullRequest filters out non-mergeable pull requests.
+// required may be nil to require every status and check run to succeed,
+// approval may be nil to not require any reviews.
+// onReject is called for every filtered pull request and may be nil
+func verifyPullRequest(issueClient IssueGetter, statusClient StatusGetter, checkClient CheckRunLister, mergeLabel string, required requiredChecks, approval approvalChecker, input <-chan *github.PullRequest, onReject func(rejection)) <-chan *github.PullRequest {
+	reject := func(pr *github.PullRequest, v verdict, reason string) {
+		log.Printf("%s/%s: pr %d %s.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber(), reason)
+		if onReject != nil {
+			onReject(rejection{PR: pr, Verdict: v, Reason: reason})
+		}
+	}
+
 	ret := make(chan *github.PullRequest)
 	go func() {
 		for pr := range input {
 			if pr.GetState() != "open" {
-				log.Printf("%s/%s: pr %d is %s.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber(), pr.GetState())
+				reject(pr, rejectIgnored, fmt.Sprintf("is %s", pr.GetState()))
 				continue
 			}
 
@@ -44,24 +177,40 @@ func verifyPullRequest(issueClient IssueGetter, statusClient StatusGetter, merge
 				mergeable = mergeable || strings.EqualFold(*label.Name, mergeLabel)
 			}
 
-			if !mergeable || (pr.Mergeable != nil && !*pr.Mergeable) {
-				log.Printf("%s/%s: pr %d is not mergeable.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber())
+			if !mergeable {
+				reject(pr, rejectIgnored, fmt.Sprintf("is not labeled %q", mergeLabel))
 				continue
 			}
 
-			status, _, err := statusClient.GetCombinedStatus(
-				context.Background(),
-				pr.Base.Repo.Owner.GetLogin(),
-				pr.Base.Repo.GetName(),
-				*pr.Head.SHA,
-				&github.ListOptions{},
-			)
+			if pr.Mergeable != nil && !*pr.Mergeable {
+				reject(pr, rejectFailed, "is not mergeable")
+				continue
+			}
+
+			if approval != nil {
+				reason, err := approval(pr)
+				if err != nil {
+					log.Printf("%s/%s: pr %d failed to check reviews %s.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber(), err.Error())
+					continue
+				}
+				if reason != "" {
+					reject(pr, rejectBlocked, reason)
+					continue
+				}
+			}
+
+			state, reason, err := evaluateCommit(statusClient, checkClient, pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.Head.GetSHA(), required)
 			if err != nil {
+				log.Printf("%s/%s: pr %d failed to evaluate statuses %s.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber(), err.Error())
+				continue
+			}
+			if state == "pending" {
+				reject(pr, rejectPending, reason)
 				continue
 			}
 
-			if status.GetState() != "success" {
-				log.Printf("%s/%s: pr %d status is %s.\n", pr.Base.Repo.Owner.GetLogin(), pr.Base.Repo.GetName(), pr.GetNumber(), status.GetState())
+			if state != "success" {
+				reject(pr, rejectFailed, reason)
 				continue
 			}
 
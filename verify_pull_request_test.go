@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/google/go-github/github"
+	"github.com/nicolai86/github-rebase-bot/checks"
 )
 
 type fakeIssueGetter func() (*github.Issue, *github.Response, error)
@@ -19,12 +20,78 @@ func (f fakeStatusGetter) GetCombinedStatus(ctx context.Context, _ string, _ str
 	return f()
 }
 
+type fakeCheckRunLister func() ([]*checks.CheckRun, *github.Response, error)
+
+func (f fakeCheckRunLister) ListCheckRunsForRef(ctx context.Context, _ string, _ string, _ string) ([]*checks.CheckRun, *github.Response, error) {
+	return f()
+}
+
+var noCheckRuns = fakeCheckRunLister(func() ([]*checks.CheckRun, *github.Response, error) {
+	return nil, nil, nil
+})
+
+func TestCombinedState(t *testing.T) {
+	run := func(status, conclusion string) *checks.CheckRun {
+		return &checks.CheckRun{Status: stringVal(status), Conclusion: stringVal(conclusion)}
+	}
+	for name, tc := range map[string]struct {
+		status   *github.CombinedStatus
+		runs     []*checks.CheckRun
+		expected string
+	}{
+		"statuses only": {
+			status:   &github.CombinedStatus{State: stringVal("failure"), TotalCount: intVal(1)},
+			expected: "failure",
+		},
+		"check runs only": {
+			status:   &github.CombinedStatus{State: stringVal("pending"), TotalCount: intVal(0)},
+			runs:     []*checks.CheckRun{run("completed", "success")},
+			expected: "success",
+		},
+		"pending check runs": {
+			status:   &github.CombinedStatus{State: stringVal("success"), TotalCount: intVal(1)},
+			runs:     []*checks.CheckRun{run("in_progress", "")},
+			expected: "pending",
+		},
+		"failing check runs": {
+			status:   &github.CombinedStatus{State: stringVal("pending"), TotalCount: intVal(1)},
+			runs:     []*checks.CheckRun{run("completed", "failure")},
+			expected: "failure",
+		},
+		"ignores own status": {
+			status: &github.CombinedStatus{State: stringVal("pending"), TotalCount: intVal(2), Statuses: []github.RepoStatus{
+				{Context: stringVal("ci"), State: stringVal("success")},
+				{Context: stringVal(statusContext), State: stringVal("pending")},
+			}},
+			expected: "success",
+		},
+		"only own status": {
+			status: &github.CombinedStatus{State: stringVal("pending"), TotalCount: intVal(1), Statuses: []github.RepoStatus{
+				{Context: stringVal(statusContext), State: stringVal("failure")},
+			}},
+			runs:     []*checks.CheckRun{run("completed", "success")},
+			expected: "success",
+		},
+		"failing statuses": {
+			status:   &github.CombinedStatus{State: stringVal("error"), TotalCount: intVal(1)},
+			runs:     []*checks.CheckRun{run("completed", "success")},
+			expected: "error",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if state := combinedState(tc.status, tc.runs); state != tc.expected {
+				t.Fatalf("Expected %q, but got %q", tc.expected, state)
+			}
+		})
+	}
+}
+
 func TestVerifyPullRequest_Filters(t *testing.T) {
 	mergeLabel := "Ready to Merge"
 	t.Run("closed pull-requests", func(t *testing.T) {
 		ch := make(chan *github.PullRequest, 1)
 
-		prs := verifyPullRequest(nil, nil, mergeLabel, ch)
+		prs := verifyPullRequest(nil, nil, nil, mergeLabel, nil, nil, ch, nil)
 		ch <- &github.PullRequest{
 			State:  stringVal("closed"),
 			Number: intVal(1),
@@ -53,7 +120,7 @@ func TestVerifyPullRequest_Filters(t *testing.T) {
 					{Name: stringVal("LGTM")},
 				},
 			}, nil, nil
-		}), nil, mergeLabel, ch)
+		}), nil, nil, mergeLabel, nil, nil, ch, nil)
 		ch <- &github.PullRequest{
 			State:  stringVal("open"),
 			Number: intVal(1),
@@ -88,7 +155,7 @@ func TestVerifyPullRequest_Filters(t *testing.T) {
 				State: stringVal("success"),
 			}, nil, nil
 		})
-		prs := verifyPullRequest(issueClient, statusClient, mergeLabel, ch)
+		prs := verifyPullRequest(issueClient, statusClient, noCheckRuns, mergeLabel, nil, nil, ch, nil)
 		ch <- &github.PullRequest{
 			State:  stringVal("open"),
 			Number: intVal(1),
@@ -128,7 +195,7 @@ func TestVerifyPullRequest_Filters(t *testing.T) {
 				State: stringVal("failure"),
 			}, nil, nil
 		})
-		prs := verifyPullRequest(issueClient, statusClient, mergeLabel, ch)
+		prs := verifyPullRequest(issueClient, statusClient, noCheckRuns, mergeLabel, nil, nil, ch, nil)
 		ch <- &github.PullRequest{
 			State:  stringVal("open"),
 			Number: intVal(1),
@@ -171,7 +238,7 @@ func TestVerifyPullRequest_PassThrough(t *testing.T) {
 		}, nil, nil
 	})
 
-	prs := verifyPullRequest(issueClient, statusClient, mergeLabel, ch)
+	prs := verifyPullRequest(issueClient, statusClient, noCheckRuns, mergeLabel, nil, nil, ch, nil)
 	ch <- &github.PullRequest{
 		State:  stringVal("open"),
 		Number: intVal(1),
@@ -195,3 +262,140 @@ func TestVerifyPullRequest_PassThrough(t *testing.T) {
 		t.Error("Expected open pull-requests w/ matching label to pass")
 	}
 }
+
+func TestVerifyPullRequest_Rejections(t *testing.T) {
+	mergeLabel := "Ready to Merge"
+	issueClient := fakeIssueGetter(func() (*github.Issue, *github.Response, error) {
+		return &github.Issue{
+			Labels: []github.Label{
+				{Name: stringVal(mergeLabel)},
+			},
+		}, nil, nil
+	})
+
+	for state, expected := range map[string]verdict{
+		"pending": rejectPending,
+		"failure": rejectFailed,
+		"error":   rejectFailed,
+	} {
+		t.Run(state+" status", func(t *testing.T) {
+			ch := make(chan *github.PullRequest, 1)
+			statusClient := fakeStatusGetter(func() (*github.CombinedStatus, *github.Response, error) {
+				return &github.CombinedStatus{
+					State: stringVal(state),
+				}, nil, nil
+			})
+
+			var rejections []rejection
+			prs := verifyPullRequest(issueClient, statusClient, noCheckRuns, mergeLabel, nil, nil, ch, func(r rejection) {
+				rejections = append(rejections, r)
+			})
+			ch <- &github.PullRequest{
+				State:  stringVal("open"),
+				Number: intVal(1),
+				Head: &github.PullRequestBranch{
+					Ref: stringVal("test"),
+					SHA: stringVal("098f6bcd4621d373cade4e832627b4f6"),
+				},
+				Base: &github.PullRequestBranch{
+					Repo: &github.Repository{
+						Owner: &github.User{
+							Login: stringVal("test"),
+						},
+						Name: stringVal("test"),
+					},
+				},
+				Mergeable: boolVal(true),
+			}
+			close(ch)
+			<-prs
+
+			if len(rejections) != 1 || rejections[0].Verdict != expected {
+				t.Fatalf("Expected verdict %v, but got %v", expected, rejections)
+			}
+		})
+	}
+
+	t.Run("closed pull-requests", func(t *testing.T) {
+		ch := make(chan *github.PullRequest, 1)
+		var rejections []rejection
+		prs := verifyPullRequest(nil, nil, nil, mergeLabel, nil, nil, ch, func(r rejection) {
+			rejections = append(rejections, r)
+		})
+		ch <- &github.PullRequest{
+			State:  stringVal("closed"),
+			Number: intVal(1),
+			Base: &github.PullRequestBranch{
+				Repo: &github.Repository{
+					Owner: &github.User{
+						Login: stringVal("test"),
+					},
+					Name: stringVal("test"),
+				},
+			},
+		}
+		close(ch)
+		<-prs
+
+		if len(rejections) != 1 || rejections[0].Verdict != rejectIgnored {
+			t.Fatalf("Expected closed pull request to be ignored, but got %v", rejections)
+		}
+	})
+
+	t.Run("unapproved pull-requests", func(t *testing.T) {
+		ch := make(chan *github.PullRequest, 1)
+		var rejections []rejection
+		approval := func(*github.PullRequest) (string, error) {
+			return "has 0 of 1 required approvals", nil
+		}
+		prs := verifyPullRequest(issueClient, nil, nil, mergeLabel, nil, approval, ch, func(r rejection) {
+			rejections = append(rejections, r)
+		})
+		ch <- &github.PullRequest{
+			State:  stringVal("open"),
+			Number: intVal(1),
+			Base: &github.PullRequestBranch{
+				Repo: &github.Repository{
+					Owner: &github.User{
+						Login: stringVal("test"),
+					},
+					Name: stringVal("test"),
+				},
+			},
+			Mergeable: boolVal(true),
+		}
+		close(ch)
+		<-prs
+
+		if len(rejections) != 1 || rejections[0].Verdict != rejectBlocked || rejections[0].Reason != "has 0 of 1 required approvals" {
+			t.Fatalf("Expected unapproved pull request to be blocked, but got %v", rejections)
+		}
+	})
+}
+
+func TestCommitChecker(t *testing.T) {
+	// github actions only report check runs, the combined status stays pending
+	statuses := fakeStatusGetter(func() (*github.CombinedStatus, *github.Response, error) {
+		return &github.CombinedStatus{State: stringVal("pending")}, nil, nil
+	})
+	runs := fakeCheckRunLister(func() ([]*checks.CheckRun, *github.Response, error) {
+		return []*checks.CheckRun{
+			{Name: stringVal("build"), Status: stringVal("completed"), Conclusion: stringVal("success")},
+			{Name: stringVal("lint"), Status: stringVal("completed"), Conclusion: stringVal("failure")},
+		}, nil, nil
+	})
+
+	t.Run("evaluates check runs", func(t *testing.T) {
+		c := commitChecker{owner: "test", name: "test", statuses: statuses, runs: runs}
+		if state, _, err := c.State("abc"); err != nil || state != "failure" {
+			t.Fatalf("Expected failure, but got %q (%v)", state, err)
+		}
+	})
+
+	t.Run("considers required checks only", func(t *testing.T) {
+		c := commitChecker{owner: "test", name: "test", statuses: statuses, runs: runs, required: staticChecks([]string{"build"})}
+		if state, _, err := c.State("abc"); err != nil || state != "success" {
+			t.Fatalf("Expected success, but got %q (%v)", state, err)
+		}
+	})
+}
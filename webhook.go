@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errInvalidSignature = errors.New("signature mismatch")
+)
+
+// webhookSecret is the master secret of all webhooks. Configured secrets are
+// used as-is, generated secrets are never seen by operators, so a distinct
+// secret is derived from them for every repository
+type webhookSecret struct {
+	key    []byte
+	derive bool
+}
+
+// For returns the webhook secret of a single repository.
+// Derived secrets are stable across restarts as long as the master secret is
+func (s webhookSecret) For(owner, name string) string {
+	if !s.derive {
+		return string(s.key)
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(fmt.Sprintf("%s/%s", owner, name)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadWebhookSecret returns the configured secret. If no secret is configured
+// a random one is generated once and persisted inside dataDir
+func loadWebhookSecret(secret, dataDir string) (webhookSecret, error) {
+	if secret != "" {
+		return webhookSecret{key: []byte(secret)}, nil
+	}
+
+	path := filepath.Join(dataDir, "webhook-secret")
+	b, err := ioutil.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(b))) > 0 {
+		return webhookSecret{key: []byte(strings.TrimSpace(string(b))), derive: true}, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return webhookSecret{}, err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return webhookSecret{}, err
+	}
+	generated := hex.EncodeToString(buf)
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return webhookSecret{}, err
+	}
+	if err := ioutil.WriteFile(path, []byte(generated), 0600); err != nil {
+		return webhookSecret{}, err
+	}
+	return webhookSecret{key: []byte(generated), derive: true}, nil
+}
+
+// validatePayload reads the body of a webhook request and verifies it against
+// the X-Hub-Signature-256 header, falling back to X-Hub-Signature. Form encoded
+// payloads, which github enterprise server may send, are decoded into JSON
+func validatePayload(req *http.Request, secret string) ([]byte, error) {
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashFunc func() hash.Hash
+	signature := req.Header.Get("X-Hub-Signature-256")
+	if signature != "" {
+		hashFunc = sha256.New
+		signature = strings.TrimPrefix(signature, "sha256=")
+	} else if signature = req.Header.Get("X-Hub-Signature"); signature != "" {
+		hashFunc = sha1.New
+		signature = strings.TrimPrefix(signature, "sha1=")
+	} else {
+		return nil, errMissingSignature
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, errInvalidSignature
+	}
+
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return nil, errInvalidSignature
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(payload))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(values.Get("payload")), nil
+	}
+	return payload, nil
+}
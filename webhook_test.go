@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func sign(hashFunc func() hash.Hash, secret, payload string) string {
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signedRequest(header, signature, payload string) *http.Request {
+	req, _ := http.NewRequest("POST", "/events/test/test", bytes.NewBufferString(payload))
+	if header != "" {
+		req.Header.Set(header, signature)
+	}
+	return req
+}
+
+func TestValidatePayload(t *testing.T) {
+	secret := "s3cr3t"
+	payload := `{"action":"opened"}`
+
+	t.Run("accepts sha256 signatures", func(t *testing.T) {
+		req := signedRequest("X-Hub-Signature-256", "sha256="+sign(sha256.New, secret, payload), payload)
+		b, err := validatePayload(req, secret)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(b) != payload {
+			t.Fatalf("Expected payload %q, but got %q", payload, string(b))
+		}
+	})
+
+	t.Run("accepts sha1 signatures", func(t *testing.T) {
+		req := signedRequest("X-Hub-Signature", "sha1="+sign(sha1.New, secret, payload), payload)
+		if _, err := validatePayload(req, secret); err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	t.Run("decodes form encoded payloads", func(t *testing.T) {
+		form := "payload=" + url.QueryEscape(payload)
+		req := signedRequest("X-Hub-Signature", "sha1="+sign(sha1.New, secret, form), form)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		b, err := validatePayload(req, secret)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(b) != payload {
+			t.Fatalf("Expected payload %q, but got %q", payload, string(b))
+		}
+	})
+
+	t.Run("rejects missing signatures", func(t *testing.T) {
+		req := signedRequest("", "", payload)
+		if _, err := validatePayload(req, secret); err != errMissingSignature {
+			t.Fatalf("Expected %v, but got %v", errMissingSignature, err)
+		}
+	})
+
+	t.Run("rejects signatures using a different secret", func(t *testing.T) {
+		req := signedRequest("X-Hub-Signature-256", "sha256="+sign(sha256.New, "other", payload), payload)
+		if _, err := validatePayload(req, secret); err != errInvalidSignature {
+			t.Fatalf("Expected %v, but got %v", errInvalidSignature, err)
+		}
+	})
+
+	t.Run("rejects tampered payloads", func(t *testing.T) {
+		req := signedRequest("X-Hub-Signature-256", "sha256="+sign(sha256.New, secret, payload), `{"action":"closed"}`)
+		if _, err := validatePayload(req, secret); err != errInvalidSignature {
+			t.Fatalf("Expected %v, but got %v", errInvalidSignature, err)
+		}
+	})
+}
+
+func TestLoadWebhookSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("prefers configured secret", func(t *testing.T) {
+		s, err := loadWebhookSecret("configured", dir)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, name := range []string{"a", "b"} {
+			if got := s.For("test", name); got != "configured" {
+				t.Fatalf("Expected configured secret for test/%s, but got %q", name, got)
+			}
+		}
+	})
+
+	t.Run("persists generated secret", func(t *testing.T) {
+		s1, err := loadWebhookSecret("", dir)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		s2, err := loadWebhookSecret("", dir)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(s1.key) == 0 || s1.For("test", "a") != s2.For("test", "a") {
+			t.Fatalf("Expected identical secrets, but got %q and %q", s1.For("test", "a"), s2.For("test", "a"))
+		}
+	})
+
+	t.Run("derives distinct secrets per repository from generated secrets", func(t *testing.T) {
+		s := webhookSecret{key: []byte("generated"), derive: true}
+		if s.For("test", "a") == s.For("test", "b") {
+			t.Fatal("Expected different secrets per repository, but got identical ones")
+		}
+		if s.For("test", "a") != s.For("test", "a") {
+			t.Fatal("Expected stable secrets, but got different ones")
+		}
+	})
+}